@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/junikimm717/dev106/internal/shared"
+)
+
+// Runs as a git credential helper: forwards the request on stdin to the CLI
+// over the session's socket, which answers it with the host's git, and
+// prints the answer. Without a CLI to ask, there are no credentials.
+func credentialHelper(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: bootstrap", shared.GIT_CREDENTIAL_HELPER_ARG, "get|store|erase")
+		return 1
+	}
+	socket := os.Getenv(shared.GIT_CREDENTIAL_SOCK_ENV)
+	if socket == "" {
+		return 0
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		// the session outlived the CLI that started it.
+		return 0
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "%s\n", args[0]); err != nil {
+		fmt.Fprintln(os.Stderr, "dev106 credential helper:", err)
+		return 1
+	}
+	if _, err := io.Copy(conn, os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, "dev106 credential helper:", err)
+		return 1
+	}
+	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
+		fmt.Fprintln(os.Stderr, "dev106 credential helper:", err)
+		return 1
+	}
+	if _, err := io.Copy(os.Stdout, conn); err != nil {
+		fmt.Fprintln(os.Stderr, "dev106 credential helper:", err)
+		return 1
+	}
+	return 0
+}
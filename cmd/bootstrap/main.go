@@ -2,17 +2,20 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/junikimm717/dev106/internal/shared"
 	"github.com/junikimm717/dev106/internal/container"
+	"github.com/junikimm717/dev106/internal/shared"
 )
 
 var (
@@ -46,24 +49,58 @@ func chown() {
 		GID,
 	)
 	if err != nil {
-		log.Println(err)
+		warn("While chowning: " + err.Error())
+	}
+	// the ssh agent socket Docker Desktop forwards belongs to root.
+	err = os.Chown(shared.SSH_AGENT_SOCK, UID, GID)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		warn("While chowning the ssh agent socket: " + err.Error())
 	}
 }
 
-func writeEtc() {
+func writeEtc() error {
 	etc, err := container.ReadEtc()
 	if err != nil {
-		log.Println("While reading etc: " + err.Error())
-		return
+		return errors.New("While reading etc: " + err.Error())
 	}
 	os.MkdirAll(shared.CONTAINER_HOME, 0o755)
 	log.Printf("Creating dev106 user with %d:%d and home %s\n", UID, GID, shared.CONTAINER_HOME)
 	etc.SetUIDGID(UID, GID, shared.CONTAINER_HOME)
 	err = etc.Writeback()
 	if err != nil {
-		log.Println("While writing back to /etc: " + err.Error())
-		return
+		return errors.New("While writing back to /etc: " + err.Error())
 	}
+	return nil
+}
+
+// runs the executables in HOOKS_DIR in lexical order, stopping at the first
+// one that fails.
+func runHooks() error {
+	entries, err := os.ReadDir(shared.HOOKS_DIR)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Mode()&0o111 == 0 {
+			continue
+		}
+		path := filepath.Join(shared.HOOKS_DIR, entry.Name())
+		log.Println("Running hook", path)
+		cmd := exec.Command(path)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("hook %s: %w", path, err)
+		}
+	}
+	return nil
 }
 
 func initLoop() {
@@ -80,7 +117,13 @@ func initLoop() {
 		}
 	}()
 
-	for range sigCh {
+	// as pid 1 we get no default signal handling, so `docker stop` would
+	// otherwise have to wait for its timeout and SIGKILL us.
+	for sig := range sigCh {
+		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
+			log.Println("Received", sig, "shutting down")
+			os.Exit(0)
+		}
 	}
 }
 
@@ -94,15 +137,34 @@ func execCommand(args []string) error {
 }
 
 func main() {
+	// git runs us in sessions; that has nothing to do with bootstrapping.
+	if len(os.Args) > 1 && os.Args[1] == shared.GIT_CREDENTIAL_HELPER_ARG {
+		os.Exit(credentialHelper(os.Args[2:]))
+	}
+
+	setPhase(shared.PhaseStarting)
 	err := initEnvVars()
 	if err != nil {
-		log.Println(err)
+		warn(err.Error())
 		log.Println("Skipping etc overwriting and chown...")
 	} else {
+		setPhase(shared.PhaseEtcRewrite)
 		log.Println("Writing to etc")
-		writeEtc()
-		log.Println("Chowning directories in", CHOWN)
-		chown()
+		if err := writeEtc(); err != nil {
+			fail(err)
+		} else {
+			setPhase(shared.PhaseChown)
+			log.Println("Chowning directories in", CHOWN)
+			chown()
+		}
+	}
+	if status.Error == "" {
+		setPhase(shared.PhaseHooks)
+		if err := runHooks(); err != nil {
+			fail(err)
+		} else {
+			setPhase(shared.PhaseReady)
+		}
 	}
 	if len(os.Args) < 2 {
 		log.Print("Going on standard init loop...")
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/junikimm717/dev106/internal/shared"
+)
+
+// what we have published to STATUS_FILE so far.
+var status shared.BootstrapStatus
+
+// writes status to STATUS_FILE. The file is replaced atomically so the CLI
+// never reads a partial write.
+func writeStatus() {
+	status.Time = time.Now()
+	data, err := json.Marshal(&status)
+	if err != nil {
+		log.Println("While encoding status: " + err.Error())
+		return
+	}
+	if err := os.MkdirAll(shared.STATUS_DIR, 0o755); err != nil {
+		log.Println("While writing status: " + err.Error())
+		return
+	}
+	tmp := filepath.Join(shared.STATUS_DIR, ".status.json.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Println("While writing status: " + err.Error())
+		return
+	}
+	if err := os.Rename(tmp, shared.STATUS_FILE); err != nil {
+		log.Println("While writing status: " + err.Error())
+	}
+}
+
+func setPhase(phase shared.Phase) {
+	status.Phase = phase
+	writeStatus()
+}
+
+// records that the current phase failed. The container keeps running so the
+// failure can be looked into, but the CLI won't open a shell into it.
+func fail(err error) {
+	log.Println(shared.LOG_ERROR_PREFIX + err.Error())
+	status.Error = err.Error()
+	writeStatus()
+}
+
+func warn(msg string) {
+	log.Println(shared.LOG_WARNING_PREFIX + msg)
+	status.Warnings = append(status.Warnings, msg)
+	writeStatus()
+}
@@ -1,13 +1,30 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/docker/go-units"
+	"github.com/junikimm717/dev106/internal/cli"
+	"github.com/moby/moby/api/types/container"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 func pullCmd() *cobra.Command {
-	return &cobra.Command{
+	var quiet bool
+	cmd := &cobra.Command{
 		Use:   "pull",
 		Short: "Pull container image",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -15,28 +32,199 @@ func pullCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			return app.Client.Pull(app.Config)
+			return app.Client.Pull(app.Config, quiet)
 		},
 	}
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "only print the digest of the pulled image")
+	return cmd
 }
 
-func startCmd() *cobra.Command {
+func loginCmd() *cobra.Command {
+	var username string
+	var passwordStdin bool
+	cmd := &cobra.Command{
+		Use:   "login [server]",
+		Short: "Log in to a container registry",
+		Long: `Log in to a container registry, storing the credentials like docker login
+does. Defaults to the registry of the configured image.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var server string
+			if len(args) > 0 {
+				server = args[0]
+			} else if app, err := newApp(true); err == nil {
+				if server, err = cli.ImageRegistry(app.Config.Image); err != nil {
+					return err
+				}
+			} else {
+				server = cli.DockerHubServer
+			}
+			server = cli.RegistryServer(server)
+
+			if username == "" {
+				if !interactive() {
+					return fmt.Errorf("--username is required when stdin is not a terminal")
+				}
+				var err error
+				if username, err = prompt(fmt.Sprintf("Username for %s: ", server)); err != nil {
+					return err
+				}
+			}
+
+			var password string
+			if passwordStdin {
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				password = strings.TrimRight(string(data), "\r\n")
+			} else {
+				if !interactive() {
+					return fmt.Errorf("use --password-stdin when stdin is not a terminal")
+				}
+				var err error
+				if password, err = promptPassword("Password: "); err != nil {
+					return err
+				}
+			}
+			if username == "" || password == "" {
+				return fmt.Errorf("username and password are required")
+			}
+
+			client := cli.NewClient(context.Background())
+			if err := client.Login(server, username, password); err != nil {
+				return err
+			}
+			fmt.Printf("Logged in to %s\n", server)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&username, "username", "u", "", "registry username")
+	cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password or token from stdin")
+	return cmd
+}
+
+func updateCmd() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Check the registry for a newer image",
+		Long: `Compare the local copy of the configured image with the registry's, and
+offer to pull the new image and recreate the current repository's container.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(true)
+			if err != nil {
+				return err
+			}
+			if app.Config.Build != nil {
+				return fmt.Errorf("the image is built from the [build] section; run `dev106 build` instead")
+			}
+			update, err := app.Client.CheckUpdate(app.Config.Image)
+			if err != nil {
+				return err
+			}
+			_ = cli.RecordUpdateCheck()
+			fmt.Println(update)
+			if !update.Available() {
+				return nil
+			}
+
+			// only the current repository's container is recreated; others
+			// show up as out of date the next time they are used.
+			var state container.ContainerState
+			if app.Root != "" {
+				if state, err = app.Client.ContainerState(app.ContainerName); err != nil {
+					return err
+				}
+			}
+			question := "Pull the new image?"
+			if state != "" {
+				question = "Pull the new image and recreate the container? Changes outside /workspace will be lost."
+			}
+			if !yes && !confirm(question, false) {
+				return nil
+			}
+
+			if state != "" {
+				if err := app.keepPlatform(); err != nil {
+					return err
+				}
+			}
+			if err := app.Client.Pull(app.Config, false); err != nil {
+				return err
+			}
+			if state == "" {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Killing container %s\n", app.ContainerName)
+			if err := app.Client.Delete(app.ContainerName); err != nil {
+				return err
+			}
+			return app.start()
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "pull and recreate without asking")
+	return cmd
+}
+
+func buildCmd() *cobra.Command {
 	return &cobra.Command{
+		Use:   "build",
+		Short: "Build the image from the config's [build] section",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(true)
+			if err != nil {
+				return err
+			}
+			if app.Config.Build == nil {
+				return fmt.Errorf("no [build] section in the config; see `dev106 config edit`")
+			}
+			if err := app.resolveImage(); err != nil {
+				return err
+			}
+			if err := app.Client.Build(app.Config); err != nil {
+				return err
+			}
+			fmt.Printf("Built %s\n", app.Config.Image)
+			return nil
+		},
+	}
+}
+
+func startCmd() *cobra.Command {
+	cmd := &cobra.Command{
 		Use:   "start",
-		Short: "Start container",
+		Short: "Start container, or resume a stopped one",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			app, err := newApp(false)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Starting new container %s\n", app.ContainerName)
-			return app.Client.Run(app.Config, app.ContainerName, app.Binds)
+			return app.ensure()
 		},
 	}
+	addRecreateFlags(cmd)
+	return cmd
 }
 
-func killCmd() *cobra.Command {
+func stopCmd() *cobra.Command {
 	return &cobra.Command{
+		Use:   "stop",
+		Short: "Stop container, keeping it to be resumed later",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Stopping container %s\n", app.ContainerName)
+			return app.Client.Stop(app.ContainerName)
+		},
+	}
+}
+
+func killCmd() *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
 		Use:   "kill",
 		Short: "Kill and delete container",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -44,8 +232,133 @@ func killCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Killing container %s\n", app.ContainerName)
-			return app.Client.Delete(app.ContainerName)
+
+			// tmux sessions are meant to outlive the terminal; don't take
+			// what's running in them down without asking.
+			state, err := app.Client.ContainerState(app.ContainerName)
+			if err != nil {
+				return err
+			}
+			if state == container.StateRunning && !force {
+				panes, err := app.Client.BusyTmuxPanes(app.ContainerName)
+				if err != nil {
+					return err
+				}
+				if len(panes) > 0 {
+					fmt.Fprintf(os.Stderr, "Warning: tmux sessions in %s are still running:\n", app.ContainerName)
+					for _, pane := range panes {
+						fmt.Fprintf(os.Stderr, "  - %s (%s)\n", pane.Target, pane.Command)
+					}
+					if !confirm("Kill them along with the container?", false) {
+						fmt.Fprintln(os.Stderr, "Not killing the container (pass --force to kill it anyway).")
+						return nil
+					}
+				}
+			}
+
+			fmt.Fprintf(os.Stderr, "Killing container %s\n", app.ContainerName)
+			if err := app.Client.Delete(app.ContainerName); err != nil {
+				return err
+			}
+			return cli.RemoveSession(app.ContainerName)
+		},
+	}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "kill even if tmux sessions are running programs")
+	return cmd
+}
+
+// runs in the background, started by the first session that needs it.
+func sessionRelayCmd() *cobra.Command {
+	var features cli.RelayFeatures
+	cmd := &cobra.Command{
+		Use:    cli.RelayCommand + " <session-dir> <repo-root>",
+		Short:  "Serve the ssh agent and git credential sockets of a container",
+		Args:   cobra.ExactArgs(2),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cli.ServeSession(args[0], args[1], features)
+		},
+	}
+	cmd.Flags().BoolVar(&features.SSHAgent, "ssh-agent", false, "relay the agent socket to the host's ssh agent")
+	cmd.Flags().BoolVar(&features.GitCredentials, "git-credentials", false, "answer git credential requests")
+	return cmd
+}
+
+func attachCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attach [session]",
+		Short: "Attach to a tmux session in the container, creating it if needed",
+		Long: `Attach to a named tmux session in the container, creating it if needed.
+Sessions keep running when the terminal is closed or detached (ctrl-b d), so
+attach again to pick up where you left off. The session defaults to "` + cli.DefaultTmuxSession + `".`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := cli.DefaultTmuxSession
+			if len(args) > 0 {
+				name = args[0]
+			}
+			if err := cli.ValidateTmuxSession(name); err != nil {
+				return err
+			}
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			if err := app.ensure(); err != nil {
+				return err
+			}
+			opts, err := app.startSession()
+			if err != nil {
+				return err
+			}
+			opts.Cmd = cli.TmuxAttachCmd(name)
+			return app.Client.Exec(app.ContainerName, opts)
+		},
+	}
+	addRecreateFlags(cmd)
+	addWorkdirFlag(cmd)
+	return cmd
+}
+
+func sessionsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sessions",
+		Short: "List the tmux sessions in the container",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			state, err := app.Client.ContainerState(app.ContainerName)
+			if err != nil {
+				return err
+			}
+			if state != container.StateRunning {
+				return fmt.Errorf("container %s is not running", app.ContainerName)
+			}
+			sessions, err := app.Client.TmuxSessions(app.ContainerName)
+			if err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				fmt.Println("No tmux sessions; start one with `dev106 attach`.")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SESSION\tWINDOWS\tATTACHED\tCREATED")
+			for _, s := range sessions {
+				attached := "no"
+				if s.Attached {
+					attached = "yes"
+				}
+				fmt.Fprintf(
+					w, "%s\t%d\t%s\t%s ago\n",
+					s.Name, s.Windows, attached, units.HumanDuration(time.Since(s.Created)),
+				)
+			}
+			return w.Flush()
 		},
 	}
 }
@@ -60,19 +373,22 @@ func restartCmd() *cobra.Command {
 				return err
 			}
 
-			fmt.Printf("Killing container %s\n", app.ContainerName)
+			if err := app.keepPlatform(); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "Killing container %s\n", app.ContainerName)
 			if err := app.Client.Delete(app.ContainerName); err != nil {
 				return err
 			}
 
-			fmt.Printf("Starting new container %s\n", app.ContainerName)
-			return app.Client.Run(app.Config, app.ContainerName, app.Binds)
+			return app.start()
 		},
 	}
 }
 
 func execCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "exec [command] [args...]",
 		Short: "Execute a command in the container",
 		Args:  cobra.MinimumNArgs(1),
@@ -81,26 +397,25 @@ func execCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-
-			exists, err := app.Client.ContainerExists(app.ContainerName)
-			if err != nil {
+			if err := app.ensure(); err != nil {
 				return err
 			}
-
-			if !exists {
-				fmt.Printf("Starting new container %s\n", app.ContainerName)
-				if err := app.Client.Run(app.Config, app.ContainerName, app.Binds); err != nil {
-					return err
-				}
+			opts, err := app.startSession()
+			if err != nil {
+				return err
 			}
-
-			return app.Client.ExecCmd(app.ContainerName, args)
+			return app.Client.ExecCmd(app.ContainerName, args, opts)
 		},
 	}
+	// flags after the command belong to the command.
+	cmd.Flags().SetInterspersed(false)
+	addRecreateFlags(cmd)
+	addWorkdirFlag(cmd)
+	return cmd
 }
 
 func shellCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "shell",
 		Short: "Open shell in container",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -111,20 +426,307 @@ func shellCmd() *cobra.Command {
 			return shell(app)
 		},
 	}
+	addRecreateFlags(cmd)
+	addWorkdirFlag(cmd)
+	return cmd
 }
 
 func shell(app *App) error {
-	exists, err := app.Client.ContainerExists(app.ContainerName)
+	update := app.checkForUpdate()
+	if err := app.ensure(); err != nil {
+		return err
+	}
+	if notice := <-update; notice != "" {
+		fmt.Fprintln(os.Stderr, notice)
+	}
+	opts, err := app.startSession()
 	if err != nil {
 		return err
 	}
+	return app.Client.Exec(app.ContainerName, opts)
+}
 
-	if !exists {
-		fmt.Printf("Starting new container %s\n", app.ContainerName)
-		if err := app.Client.Run(app.Config, app.ContainerName, app.Binds); err != nil {
-			return err
-		}
+func limitsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "limits",
+		Short: "Show the resource limits of the running container",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			hostConfig, err := app.Client.HostConfig(app.ContainerName)
+			if err != nil {
+				return err
+			}
+
+			size := func(n int64) string {
+				if n <= 0 {
+					return "unlimited"
+				}
+				return units.BytesSize(float64(n))
+			}
+			cpus := "unlimited"
+			if hostConfig.NanoCPUs > 0 {
+				cpus = strconv.FormatFloat(float64(hostConfig.NanoCPUs)/1e9, 'f', -1, 64)
+			}
+			cpuset := "all"
+			if hostConfig.CpusetCpus != "" {
+				cpuset = hostConfig.CpusetCpus
+			}
+			pids := "unlimited"
+			if hostConfig.PidsLimit != nil && *hostConfig.PidsLimit > 0 {
+				pids = strconv.FormatInt(*hostConfig.PidsLimit, 10)
+			}
+
+			fmt.Printf("cpus:        %s\n", cpus)
+			fmt.Printf("cpuset:      %s\n", cpuset)
+			fmt.Printf("memory:      %s\n", size(hostConfig.Memory))
+			fmt.Printf("memory_swap: %s\n", size(hostConfig.MemorySwap))
+			fmt.Printf("pids_limit:  %s\n", pids)
+			fmt.Printf("shm_size:    %s\n", size(hostConfig.ShmSize))
+			return nil
+		},
+	}
+}
+
+func portCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "port",
+		Short: "Show the ports the container publishes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			ports, err := app.Client.PublishedPorts(app.ContainerName)
+			if err != nil {
+				return err
+			}
+			names := make([]string, 0, len(ports))
+			bindings := make(map[string][]string, len(ports))
+			for port, published := range ports {
+				name := port.String()
+				names = append(names, name)
+				for _, binding := range published {
+					host := "0.0.0.0"
+					if binding.HostIP.IsValid() {
+						host = binding.HostIP.String()
+					}
+					bindings[name] = append(bindings[name], net.JoinHostPort(host, binding.HostPort))
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, binding := range bindings[name] {
+					fmt.Printf("%s -> %s\n", name, binding)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(portForwardCmd())
+	return cmd
+}
+
+func portForwardCmd() *cobra.Command {
+	var localPort int
+	var address string
+	cmd := &cobra.Command{
+		Use:   "forward <container-port>",
+		Short: "Forward a local port to a port in the running container",
+		Long: `Forward a local port to a port in the running container, without publishing
+it. Connections are relayed through docker exec, using socat if the image has
+it and bash otherwise.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, err := strconv.Atoi(args[0])
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("invalid port %q", args[0])
+			}
+			if localPort == 0 {
+				localPort = port
+			}
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			state, err := app.Client.ContainerState(app.ContainerName)
+			if err != nil {
+				return err
+			}
+			if state != container.StateRunning {
+				return fmt.Errorf("container %s is not running; run `dev106 start` first", app.ContainerName)
+			}
+
+			listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(localPort)))
+			if err != nil {
+				return err
+			}
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(sigCh)
+			go func() {
+				<-sigCh
+				listener.Close()
+			}()
+
+			fmt.Fprintf(os.Stderr, "Forwarding %s to port %d in %s; press ^C to stop\n", listener.Addr(), port, app.ContainerName)
+			return app.Client.ForwardPort(app.ContainerName, listener, port)
+		},
 	}
+	cmd.Flags().IntVarP(&localPort, "local-port", "l", 0, "local port to listen on (defaults to the container port)")
+	cmd.Flags().StringVar(&address, "address", "127.0.0.1", "local address to listen on")
+	return cmd
+}
 
-	return app.Client.Exec(app.ContainerName)
+func lsCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List your dev106 containers",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// doesn't need the config, so it works before `dev106 config init`
+			// and in repositories whose .dev106.toml isn't trusted.
+			client := cli.NewClient(context.Background())
+			containers, err := client.ListContainers()
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(containers)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tREPO\tPROFILE\tSTATE\tIMAGE\tUPTIME\tOUTDATED")
+			for _, c := range containers {
+				repo := c.Repo
+				if repo == "" {
+					repo = "?"
+				}
+				profile := c.Profile
+				if profile == "" {
+					profile = "-"
+				}
+				uptime := "-"
+				if c.State == "running" && !c.StartedAt.IsZero() {
+					uptime = units.HumanDuration(c.Uptime())
+				}
+				outdated := "no"
+				if c.Outdated {
+					outdated = "yes"
+				}
+				fmt.Fprintf(
+					w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					c.Name, repo, profile, c.State, c.Image, uptime, outdated,
+				)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON instead of a table")
+	return cmd
+}
+
+// the image the config in the working directory uses. Only prune needs it,
+// so a missing or untrusted config doesn't stop the rest of prune.
+func currentImage() (string, error) {
+	app, err := newApp(true)
+	if err != nil {
+		return "", err
+	}
+	if err := app.resolveImage(); err != nil {
+		return "", err
+	}
+	return app.Config.Image, nil
+}
+
+func pruneCmd() *cobra.Command {
+	var dryRun, yes bool
+	var idle time.Duration
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove orphaned containers, unused volumes and superseded images",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := cli.NewClient(context.Background())
+			image, err := currentImage()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: not pruning images built from a [build] section: %v\n", err)
+			}
+			candidates, err := client.FindPrunable(image, idle)
+			if err != nil {
+				return err
+			}
+			if candidates.Empty() {
+				fmt.Println("Nothing to prune.")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, c := range candidates.Containers {
+				fmt.Fprintf(w, "container\t%s\t%s (%s)\n", c.Name, c.Repo, c.Reason)
+			}
+			for _, name := range candidates.Volumes {
+				fmt.Fprintf(w, "volume\t%s\tunused\n", name)
+			}
+			for _, image := range candidates.Images {
+				name := image.ID
+				if len(image.Tags) > 0 {
+					name = strings.Join(image.Tags, ", ")
+				}
+				fmt.Fprintf(
+					w, "image\t%s\t%s, superseded (%s)\n",
+					name, image.Repository, units.HumanSize(float64(image.Size)),
+				)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if dryRun {
+				return nil
+			}
+			if !yes {
+				if !interactive() {
+					return fmt.Errorf("refusing to prune without confirmation (use --yes)")
+				}
+				if !confirm("Remove all of the above?", false) {
+					return nil
+				}
+			}
+			return client.Prune(candidates)
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print what would be removed")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	cmd.Flags().DurationVar(&idle, "idle", 30*24*time.Hour, "also remove containers stopped for longer than this (0 to disable)")
+	return cmd
+}
+
+func logsCmd() *cobra.Command {
+	var opts cli.LogOptions
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show the container's logs, including the bootstrapper's",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			app, err := newApp(false)
+			if err != nil {
+				return err
+			}
+			stdout := cli.NewLogHighlighter(os.Stdout, term.IsTerminal(int(os.Stdout.Fd())))
+			stderr := cli.NewLogHighlighter(os.Stderr, term.IsTerminal(int(os.Stderr.Fd())))
+			err = app.Client.Logs(app.ContainerName, opts, stdout, stderr)
+			stdout.Flush()
+			stderr.Flush()
+			return err
+		},
+	}
+	cmd.Flags().BoolVarP(&opts.Follow, "follow", "f", false, "keep streaming new output")
+	cmd.Flags().StringVar(&opts.Since, "since", "", "only show logs since a timestamp or duration (e.g. 10m)")
+	cmd.Flags().StringVarP(&opts.Tail, "tail", "n", "all", "number of lines to show from the end")
+	return cmd
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/junikimm717/dev106/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+// images offered by `dev106 config init`.
+var knownImages = []struct {
+	Image       string
+	Description string
+}{
+	{"ghcr.io/junikimm717/dev106/nvim:2.1.0", "neovim + 6.106 toolchain, cilk 2.1.0"},
+	{"ghcr.io/junikimm717/dev106/nvim:4.0-rc1", "neovim + 6.106 toolchain, cilk 4.0-rc1"},
+	{"ghcr.io/junikimm717/dev106/mit_6106:2.1.0", "6.106 toolchain only, cilk 2.1.0"},
+	{"ghcr.io/junikimm717/dev106/mit_6106:4.0-rc1", "6.106 toolchain only, cilk 4.0-rc1"},
+}
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage dev106 configuration",
+	}
+	cmd.AddCommand(configInitCmd())
+	cmd.AddCommand(configValidateCmd())
+	cmd.AddCommand(configShowCmd())
+	cmd.AddCommand(configEditCmd())
+	cmd.AddCommand(configPathCmd())
+	cmd.AddCommand(configTrustCmd())
+	return cmd
+}
+
+// returns the global config path, or the current repository's .dev106.toml
+// if repo is set.
+func configFilePath(repo bool) (string, error) {
+	if !repo {
+		return cli.ConfigPath()
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	root, err := cli.FindRoot(wd)
+	if err != nil {
+		return "", err
+	}
+	return cli.RepoConfigPath(root), nil
+}
+
+func chooseImage() (string, error) {
+	fmt.Fprintln(os.Stderr, "Choose an image:")
+	for i, known := range knownImages {
+		fmt.Fprintf(os.Stderr, "  %d) %s (%s)\n", i+1, known.Image, known.Description)
+	}
+	fmt.Fprintf(os.Stderr, "  %d) other\n", len(knownImages)+1)
+
+	for {
+		answer, err := prompt(fmt.Sprintf("Image [1-%d, default 1]: ", len(knownImages)+1))
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			return knownImages[0].Image, nil
+		}
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < 1 || n > len(knownImages)+1 {
+			fmt.Fprintln(os.Stderr, "Please enter one of the numbers above.")
+			continue
+		}
+		if n <= len(knownImages) {
+			return knownImages[n-1].Image, nil
+		}
+		image, err := prompt("Image reference: ")
+		if err != nil {
+			return "", err
+		}
+		if image != "" {
+			return image, nil
+		}
+	}
+}
+
+func configInitCmd() *cobra.Command {
+	var image string
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create the global config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if image == "" {
+				if interactive() {
+					chosen, err := chooseImage()
+					if err != nil {
+						return err
+					}
+					image = chosen
+				} else {
+					image = cli.DefaultImage
+				}
+			}
+			path, err := cli.InitConfig(image, force)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Created config at %s\n", path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&image, "image", "", "image to use instead of asking")
+	cmd.Flags().BoolVar(&force, "force", false, "overwrite an existing config")
+	return cmd
+}
+
+func configValidateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Check the global and repository configs for mistakes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := make([]string, 0, 2)
+			global, err := cli.ConfigPath()
+			if err != nil {
+				return err
+			}
+			paths = append(paths, global)
+			if repo, err := configFilePath(true); err == nil {
+				if _, err := os.Stat(repo); err == nil {
+					paths = append(paths, repo)
+				}
+			}
+
+			var errs []error
+			for _, path := range paths {
+				if err := cli.ValidateConfigFile(path); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				fmt.Printf("%s: ok\n", path)
+			}
+			if len(errs) > 0 {
+				return errors.Join(errs...)
+			}
+
+			// the files are fine on their own; check that they also merge into
+			// something usable for the current repository.
+			app, err := newApp(true)
+			if err != nil {
+				return err
+			}
+			// the sources of mounts and env_file are otherwise only checked
+			// when a container is created.
+			if app.Root != "" {
+				if err := app.loadEnv(); err != nil {
+					return err
+				}
+				if _, err := cli.ConfigMounts(app.Config, app.Root); err != nil {
+					return err
+				}
+			}
+			fmt.Println("resolved config: ok")
+			return nil
+		},
+	}
+}
+
+func configShowCmd() *cobra.Command {
+	var resolved bool
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the global config, or the merged config with --resolved",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !resolved {
+				contents, err := cli.ReadGlobalConfig()
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(contents)
+				return err
+			}
+			app, err := newApp(true)
+			if err != nil {
+				return err
+			}
+			return app.Config.WriteResolved(os.Stdout)
+		},
+	}
+	cmd.Flags().BoolVar(&resolved, "resolved", false, "print the merged config and where each key came from")
+	return cmd
+}
+
+func editor() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+func configEditCmd() *cobra.Command {
+	var repo bool
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Open the config in $EDITOR and validate it on save",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configFilePath(repo)
+			if err != nil {
+				return err
+			}
+			if !repo {
+				if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+					if _, err := cli.InitConfig(cli.DefaultImage, false); err != nil {
+						return err
+					}
+				}
+			}
+
+			for {
+				args := append(editor(), path)
+				edit := exec.Command(args[0], args[1:]...)
+				edit.Stdin = os.Stdin
+				edit.Stdout = os.Stdout
+				edit.Stderr = os.Stderr
+				if err := edit.Run(); err != nil {
+					return err
+				}
+
+				if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+					// nothing was saved.
+					return nil
+				}
+				err := cli.ValidateConfigFile(path)
+				if err == nil {
+					fmt.Printf("%s: ok\n", path)
+					if repo {
+						return trustEdited(path)
+					}
+					return nil
+				}
+				fmt.Fprintln(os.Stderr, err)
+				if !confirm("Re-open the editor?", true) {
+					return err
+				}
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&repo, "repo", false, "edit the repository's .dev106.toml instead")
+	return cmd
+}
+
+// trusts a repository config the user just edited, since they have seen
+// all of it.
+func trustEdited(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = cli.TrustRepoConfig(path, contents)
+	return err
+}
+
+func configPathCmd() *cobra.Command {
+	var repo bool
+	cmd := &cobra.Command{
+		Use:   "path",
+		Short: "Print the path of the config file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configFilePath(repo)
+			if err != nil {
+				return err
+			}
+			fmt.Println(path)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&repo, "repo", false, "print the repository's .dev106.toml path instead")
+	return cmd
+}
+
+func configTrustCmd() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "trust",
+		Short: "Let the repository's .dev106.toml set keys such as image and mounts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configFilePath(true)
+			if err != nil {
+				return err
+			}
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !yes {
+				fmt.Fprintf(os.Stderr, "%s:\n\n%s\n", path, contents)
+				if !confirm("Trust this file?", false) {
+					return nil
+				}
+			}
+			keys, err := cli.TrustRepoConfig(path, contents)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Trusted %s\n", path)
+			if len(keys) > 0 {
+				fmt.Printf("It may now set %s\n", strings.Join(keys, ", "))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "trust the file without showing it")
+	return cmd
+}
@@ -18,3 +18,8 @@ export PATH=$HOME/go/bin:$PATH
 if test -n "$JAVA_HOME"; then
   export PATH=$JAVA_HOME/bin:$PATH
 fi
+
+# DEV_NPROC reflects the container's CPU quota, which nproc can't see.
+if test -n "$DEV_NPROC"; then
+  nproc() { echo "$DEV_NPROC"; }
+fi
@@ -0,0 +1,302 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/moby/moby/api/pkg/authconfig"
+	"github.com/moby/moby/api/types/registry"
+	dockerClient "github.com/moby/moby/client"
+)
+
+// key docker uses for Docker Hub in config.json and credential helpers.
+const DockerHubServer = "https://index.docker.io/v1/"
+
+// The parts of ~/.docker/config.json we care about.
+type dockerConfigFile struct {
+	Auths       map[string]dockerAuthEntry `json:"auths,omitempty"`
+	CredsStore  string                     `json:"credsStore,omitempty"`
+	CredHelpers map[string]string          `json:"credHelpers,omitempty"`
+}
+
+type dockerAuthEntry struct {
+	// base64 of "username:password".
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// what docker-credential-* helpers read and write.
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// helpers store identity tokens under this username.
+const tokenUsername = "<token>"
+
+// path of docker's client config, honouring $DOCKER_CONFIG like the docker CLI.
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// reads docker's client config. A missing file is the same as an empty one.
+func readDockerConfig() (*dockerConfigFile, error) {
+	path, err := dockerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	file := &dockerConfigFile{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Normalises a registry address the way docker keys credentials: Docker Hub
+// under DockerHubServer, anything else by host name alone.
+func RegistryServer(address string) string {
+	host := strings.TrimPrefix(address, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return DockerHubServer
+	}
+	return host
+}
+
+// Returns the registry an image is pulled from, e.g. ghcr.io for
+// ghcr.io/org/image:tag and DockerHubServer for ubuntu:24.04.
+func ImageRegistry(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	return RegistryServer(reference.Domain(named)), nil
+}
+
+// the credential helper responsible for server, if any.
+func (f *dockerConfigFile) helper(server string) string {
+	if helper, ok := f.CredHelpers[server]; ok {
+		return helper
+	}
+	return f.CredsStore
+}
+
+// the auths entry for server. Keys may carry a scheme or path, so they are
+// normalised before comparing.
+func (f *dockerConfigFile) authEntry(server string) (dockerAuthEntry, bool) {
+	if entry, ok := f.Auths[server]; ok {
+		return entry, true
+	}
+	for key, entry := range f.Auths {
+		if RegistryServer(key) == server {
+			return entry, true
+		}
+	}
+	return dockerAuthEntry{}, false
+}
+
+// runs `docker-credential-<helper> <action>` with input on stdin.
+func runCredentialHelper(helper, action string, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, action)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// helpers report their errors on stdout.
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("docker-credential-%s %s: %s", helper, action, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Looks up the credentials stored for server, first with the configured
+// credential helper and then in config.json itself. Returns an empty
+// AuthConfig when there are none, which makes pulls anonymous.
+func LookupAuth(server string) (registry.AuthConfig, error) {
+	auth := registry.AuthConfig{ServerAddress: server}
+	file, err := readDockerConfig()
+	if err != nil {
+		return auth, err
+	}
+
+	if helper := file.helper(server); helper != "" {
+		out, err := runCredentialHelper(helper, "get", []byte(server))
+		if err != nil {
+			if strings.Contains(err.Error(), "credentials not found") {
+				return auth, nil
+			}
+			return auth, err
+		}
+		var creds helperCredentials
+		if err := json.Unmarshal(out, &creds); err != nil {
+			return auth, fmt.Errorf("docker-credential-%s: %w", helper, err)
+		}
+		if creds.Username == tokenUsername {
+			auth.IdentityToken = creds.Secret
+		} else {
+			auth.Username = creds.Username
+			auth.Password = creds.Secret
+		}
+		return auth, nil
+	}
+
+	entry, ok := file.authEntry(server)
+	if !ok {
+		return auth, nil
+	}
+	auth.IdentityToken = entry.IdentityToken
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return auth, fmt.Errorf("invalid credentials for %s in docker config: %w", server, err)
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return auth, fmt.Errorf("invalid credentials for %s in docker config", server)
+		}
+		auth.Username = user
+		auth.Password = password
+	}
+	return auth, nil
+}
+
+// Encoded credentials for pulling image, to pass as RegistryAuth. "" if
+// there are none.
+func registryAuth(image string) (string, error) {
+	server, err := ImageRegistry(image)
+	if err != nil {
+		return "", err
+	}
+	auth, err := LookupAuth(server)
+	if err != nil {
+		return "", err
+	}
+	if auth.Username == "" && auth.Password == "" && auth.IdentityToken == "" {
+		return "", nil
+	}
+	return authconfig.Encode(auth)
+}
+
+// Saves credentials for server where the docker CLI would: with the
+// configured credential helper if there is one, and in config.json
+// otherwise. Other settings in config.json are left untouched.
+func StoreAuth(auth registry.AuthConfig) error {
+	path, err := dockerConfigPath()
+	if err != nil {
+		return err
+	}
+	file, err := readDockerConfig()
+	if err != nil {
+		return err
+	}
+
+	server := auth.ServerAddress
+	if helper := file.helper(server); helper != "" {
+		creds := helperCredentials{ServerURL: server, Username: auth.Username, Secret: auth.Password}
+		if auth.IdentityToken != "" {
+			creds.Username = tokenUsername
+			creds.Secret = auth.IdentityToken
+		}
+		input, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		_, err = runCredentialHelper(helper, "store", input)
+		return err
+	}
+
+	// decode into a generic map so keys we don't know about survive.
+	raw := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	entry := dockerAuthEntry{IdentityToken: auth.IdentityToken}
+	if auth.IdentityToken != "" {
+		entry.Auth = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":"))
+	} else {
+		entry.Auth = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	}
+	if file.Auths == nil {
+		file.Auths = map[string]dockerAuthEntry{}
+	}
+	file.Auths[server] = entry
+	auths, err := json.Marshal(file.Auths)
+	if err != nil {
+		return err
+	}
+	raw["auths"] = auths
+
+	data, err = json.MarshalIndent(raw, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Checks username and password against the registry at server through the
+// daemon and stores them if they work.
+func (d *DevClient) Login(server, username, password string) error {
+	server = RegistryServer(server)
+	resp, err := d.client.RegistryLogin(d.ctx, dockerClient.RegistryLoginOptions{
+		Username:      username,
+		Password:      password,
+		ServerAddress: server,
+	})
+	if err != nil {
+		return err
+	}
+	auth := registry.AuthConfig{
+		Username:      username,
+		Password:      password,
+		ServerAddress: server,
+		IdentityToken: resp.Auth.IdentityToken,
+	}
+	if auth.IdentityToken != "" {
+		// the token replaces the password.
+		auth.Password = ""
+	}
+	return StoreAuth(auth)
+}
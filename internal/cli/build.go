@@ -0,0 +1,519 @@
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	buildkitClient "github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/moby/moby/api/types/build"
+	"github.com/moby/moby/api/types/registry"
+	dockerClient "github.com/moby/moby/client"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// repository that locally built images are tagged in.
+const buildRepository = "dev106-local"
+
+// The [build] section: builds the image from a Dockerfile instead of pulling
+// config.Image.
+type BuildConfig struct {
+	// directory sent to the daemon. Relative paths are relative to the
+	// repository root, which is the default.
+	Context string `toml:"context,omitempty"`
+	// path of the Dockerfile within the context. Defaults to "Dockerfile".
+	Dockerfile string `toml:"dockerfile,omitempty"`
+	// values for the Dockerfile's ARGs.
+	Args map[string]string `toml:"args,omitempty"`
+	// stage to build in a multi-stage Dockerfile.
+	Target string `toml:"target,omitempty"`
+
+	// absolute path of the context, set by ResolveBuild.
+	contextDir string
+}
+
+func (b *BuildConfig) dockerfile() string {
+	if b.Dockerfile == "" {
+		return "Dockerfile"
+	}
+	return filepath.ToSlash(filepath.Clean(b.Dockerfile))
+}
+
+// Points config.Image at the tag the [build] section's image gets, which is
+// derived from the build settings and everything in the context. Does nothing
+// if there is no [build] section.
+func ResolveBuild(config *DevConfig, root string) error {
+	b := config.Build
+	if b == nil {
+		return nil
+	}
+	dir, err := expandSource(b.Context, root)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(dir) {
+		// leaves the image unresolved; only commands that don't need it
+		// run outside a repository.
+		if root == "" {
+			return nil
+		}
+		dir = filepath.Join(root, dir)
+	}
+	b.contextDir = dir
+
+	files, err := b.contextFiles()
+	if err != nil {
+		return err
+	}
+	// builds are for the host unless a platform is set, so the host's
+	// platform spelled out gets the same tag.
+	platform := HostPlatform()
+	if config.Platform != "" {
+		if platform, err = ParsePlatform(config.Platform); err != nil {
+			return err
+		}
+	}
+	hash, err := b.hash(files, FormatPlatform(platform))
+	if err != nil {
+		return err
+	}
+	config.Image = fmt.Sprintf("%s:%s", buildRepository, hash[:12])
+	config.Sources["image"] = "[build]"
+	return nil
+}
+
+// the files in the context that are sent to the daemon, relative to the
+// context, in a stable order.
+func (b *BuildConfig) contextFiles() ([]string, error) {
+	info, err := os.Stat(b.contextDir)
+	if err != nil {
+		return nil, fmt.Errorf("build: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("build: context %s is not a directory", b.contextDir)
+	}
+	if _, err := os.Stat(filepath.Join(b.contextDir, b.dockerfile())); err != nil {
+		return nil, fmt.Errorf("build: %w", err)
+	}
+
+	ignore, err := readDockerignore(b.contextDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = filepath.WalkDir(b.contextDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// git's own files change all the time and would trigger rebuilds.
+		if rel == ".git" {
+			return filepath.SkipDir
+		}
+		// like docker, the Dockerfile and .dockerignore are always sent.
+		if rel == b.dockerfile() || rel == ".dockerignore" {
+			files = append(files, rel)
+			return nil
+		}
+		ignored, err := ignore.MatchesOrParentMatches(rel)
+		if err != nil {
+			return fmt.Errorf(".dockerignore: %w", err)
+		}
+		if ignored {
+			if entry.IsDir() && !ignore.Exclusions() && !strings.HasPrefix(b.dockerfile(), rel+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// hashes the build settings together with the path, mode and contents of
+// every file in the context.
+func (b *BuildConfig) hash(files []string, platform string) (string, error) {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "dockerfile=%s\x00target=%s\x00platform=%s\x00", b.dockerfile(), b.Target, platform)
+	args := make([]string, 0, len(b.Args))
+	for name := range b.Args {
+		args = append(args, name)
+	}
+	sort.Strings(args)
+	for _, name := range args {
+		fmt.Fprintf(sum, "arg=%s=%s\x00", name, b.Args[name])
+	}
+
+	for _, rel := range files {
+		path := filepath.Join(b.contextDir, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sum, "file=%s\x00%o\x00", rel, info.Mode())
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(sum, "%s\x00", target)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(sum, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// writes files from the context to w as a tar stream.
+func (b *BuildConfig) writeContext(w io.Writer, files []string) error {
+	tw := tar.NewWriter(w)
+	for _, rel := range files {
+		path := filepath.Join(b.contextDir, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() {
+			continue
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		// ownership on the host means nothing in the image.
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// reads the .dockerignore in dir, with docker's own parser and matcher so
+// that we send the daemon exactly what `docker build` would.
+func readDockerignore(dir string) (*patternmatcher.PatternMatcher, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return patternmatcher.New(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf(".dockerignore: %w", err)
+	}
+	ignore, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf(".dockerignore: %w", err)
+	}
+	return ignore, nil
+}
+
+// registries the Dockerfile pulls base images from, so their credentials can
+// be sent along.
+func (b *BuildConfig) baseRegistries() []string {
+	f, err := os.Open(filepath.Join(b.contextDir, b.dockerfile()))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	stages := map[string]bool{}
+	var registries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		args := fields[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+		// build args and earlier stages aren't images we can look up.
+		stage := stages[strings.ToLower(args[0])]
+		if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+		if stage || strings.Contains(args[0], "$") {
+			continue
+		}
+		if _, err := reference.ParseNormalizedNamed(args[0]); err != nil {
+			continue
+		}
+		if server, err := ImageRegistry(args[0]); err == nil {
+			registries = append(registries, server)
+		}
+	}
+	return registries
+}
+
+type buildMessage struct {
+	Stream string          `json:"stream,omitempty"`
+	Aux    json.RawMessage `json:"aux,omitempty"`
+	pullMessage
+}
+
+// ID of the build messages that carry BuildKit's progress.
+const buildkitTrace = "moby.buildkit.trace"
+
+// Whether to build with BuildKit. Like the docker CLI, DOCKER_BUILDKIT=0
+// selects the classic builder, e.g. for daemons without BuildKit.
+func useBuildKit() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("DOCKER_BUILDKIT"))
+	return err != nil || enabled
+}
+
+// Answers BuildKit's requests for the credentials of the registries base
+// images come from, from the same store as pulls.
+type buildAuth struct {
+	auth.UnimplementedAuthServer
+}
+
+func (a *buildAuth) Register(server *grpc.Server) {
+	auth.RegisterAuthServer(server, a)
+}
+
+func (a *buildAuth) Credentials(ctx context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
+	creds, err := LookupAuth(RegistryServer(req.Host))
+	if err != nil {
+		return nil, err
+	}
+	if creds.IdentityToken != "" {
+		return &auth.CredentialsResponse{Secret: creds.IdentityToken}, nil
+	}
+	return &auth.CredentialsResponse{Username: creds.Username, Secret: creds.Password}, nil
+}
+
+// Builds the image described by the [build] section and tags it as
+// config.Image, streaming the build output.
+func (d *DevClient) Build(config *DevConfig) error {
+	b := config.Build
+	if b == nil {
+		return errors.New("no [build] section in the config")
+	}
+	if b.contextDir == "" {
+		return errors.New("build: the context is relative to the repository root, but we are not in a git repository")
+	}
+	platform, err := d.ResolvePlatform(config)
+	if err != nil {
+		return err
+	}
+	files, err := b.contextFiles()
+	if err != nil {
+		return err
+	}
+
+	args := make(map[string]*string, len(b.Args))
+	for name, value := range b.Args {
+		args[name] = &value
+	}
+	opts := dockerClient.ImageBuildOptions{
+		Tags:       []string{config.Image},
+		Dockerfile: b.dockerfile(),
+		BuildArgs:  args,
+		Target:     b.Target,
+		Platforms:  []v1.Platform{platform},
+		Remove:     true,
+		Version:    build.BuilderV1,
+	}
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	if useBuildKit() {
+		// BuildKit asks for registry credentials through a session rather
+		// than taking them with the request.
+		s, err := session.NewSession(ctx, b.contextDir)
+		if err != nil {
+			return err
+		}
+		s.Allow(&buildAuth{})
+		go func() {
+			_ = s.Run(ctx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+				return d.client.DialHijack(ctx, "/session", proto, meta)
+			})
+		}()
+		defer s.Close()
+		opts.Version = build.BuilderBuildKit
+		opts.SessionID = s.ID()
+	} else {
+		opts.AuthConfigs = map[string]registry.AuthConfig{}
+		for _, server := range b.baseRegistries() {
+			if auth, err := LookupAuth(server); err == nil && (auth.Username != "" || auth.IdentityToken != "") {
+				opts.AuthConfigs[server] = auth
+			}
+		}
+	}
+
+	// stream the context to the daemon as it is read.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(b.writeContext(pw, files))
+	}()
+	defer pr.Close()
+
+	resp, err := d.client.ImageBuild(ctx, pr, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if opts.Version == build.BuilderBuildKit {
+		return showBuildKitProgress(ctx, resp.Body)
+	}
+
+	// base images are pulled as part of the build; their progress is
+	// interleaved with the build's output, so it can't be drawn in place.
+	progress := &lineRenderer{}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg buildMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("docker build failed: %s", msg.Error)
+		}
+		if msg.Stream != "" {
+			fmt.Fprint(os.Stderr, msg.Stream)
+		} else {
+			progress.message(&msg.pullMessage)
+		}
+	}
+}
+
+// Shows the progress BuildKit reports in the build output the way docker
+// build does: redrawn in place on a terminal, and as plain lines otherwise.
+func showBuildKitProgress(ctx context.Context, body io.Reader) error {
+	display, err := progressui.NewDisplay(os.Stderr, progressui.AutoMode)
+	if err != nil {
+		return err
+	}
+	statuses := make(chan *buildkitClient.SolveStatus)
+	shown := make(chan error, 1)
+	go func() {
+		_, err := display.UpdateFrom(ctx, statuses)
+		shown <- err
+	}()
+
+	buildErr := func() error {
+		dec := json.NewDecoder(body)
+		for {
+			var msg buildMessage
+			if err := dec.Decode(&msg); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if msg.Error != "" {
+				return fmt.Errorf("docker build failed: %s", msg.Error)
+			}
+			if msg.ID != buildkitTrace || len(msg.Aux) == 0 {
+				continue
+			}
+			var data []byte
+			if err := json.Unmarshal(msg.Aux, &data); err != nil {
+				return err
+			}
+			var status controlapi.StatusResponse
+			if err := proto.Unmarshal(data, &status); err != nil {
+				return err
+			}
+			statuses <- buildkitClient.NewSolveStatus(&status)
+		}
+	}()
+	// let the display print what it has before reporting the error.
+	close(statuses)
+	if err := <-shown; buildErr == nil {
+		return err
+	}
+	return buildErr
+}
+
+// Builds the image for the [build] section unless an image for the current
+// Dockerfile and context already exists.
+func (d *DevClient) EnsureBuilt(config *DevConfig) error {
+	if config.Build == nil {
+		return nil
+	}
+	_, err := d.client.ImageInspect(d.ctx, config.Image)
+	if errdefs.IsNotFound(err) {
+		fmt.Fprintf(os.Stderr, "Image %s not found locally, building it\n", config.Image)
+		return d.Build(config)
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -9,11 +10,13 @@ import (
 	"os/signal"
 	"os/user"
 	"syscall"
+	"time"
 
 	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/pkg/stdcopy"
 	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
 	dockerClient "github.com/moby/moby/client"
-	"github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/term"
 )
 
@@ -33,26 +36,70 @@ func NewClient(ctx context.Context) *DevClient {
 	}
 }
 
-func (d *DevClient) Run(config *DevConfig, containerName string, binds []string) error {
+// Everything besides the config that goes into a new container.
+type RunOptions struct {
+	// repository root, mounted at /workspace.
+	Root   string
+	Binds  []string
+	Mounts []mount.Mount
+	Env    []string
+}
+
+func (d *DevClient) Run(config *DevConfig, containerName string, opts RunOptions) error {
 	u, err := user.Current()
 	if err != nil {
 		return err
 	}
+	resources, shmSize, err := config.Resources()
+	if err != nil {
+		return err
+	}
+	restartPolicy, err := config.ParseRestartPolicy()
+	if err != nil {
+		return err
+	}
+	exposedPorts, portBindings, err := config.PortBindings()
+	if err != nil {
+		return err
+	}
+	env := append([]string{
+		fmt.Sprintf("DEV_UID=%s", u.Uid),
+		fmt.Sprintf("DEV_GID=%s", u.Gid),
+	}, opts.Env...)
+	if nproc := config.NProc(); nproc > 0 {
+		env = append(env, fmt.Sprintf("DEV_NPROC=%d", nproc))
+	}
+	platform, err := d.ResolvePlatform(config)
+	if err != nil {
+		return err
+	}
+	if err := d.ensureImage(config, platform); err != nil {
+		return err
+	}
+	image, err := d.client.ImageInspect(d.ctx, config.Image)
+	if err != nil {
+		return err
+	}
+	labels, err := containerLabels(config, opts.Root, image.ID, FormatPlatform(platform))
+	if err != nil {
+		return err
+	}
 	resp, err := d.client.ContainerCreate(d.ctx, dockerClient.ContainerCreateOptions{
 		Image: config.Image,
 		Name:  containerName,
 		Config: &container.Config{
-			Env: []string{
-				fmt.Sprintf("DEV_UID=%s", u.Uid),
-				fmt.Sprintf("DEV_GID=%s", u.Gid),
-			},
-		},
-		Platform: &v1.Platform{
-			Architecture: "amd64",
-			OS:           "linux",
+			Env:          env,
+			Labels:       labels,
+			ExposedPorts: exposedPorts,
 		},
+		Platform: &platform,
 		HostConfig: &container.HostConfig{
-			Binds: binds,
+			Binds:         opts.Binds,
+			Mounts:        opts.Mounts,
+			Resources:     resources,
+			ShmSize:       shmSize,
+			RestartPolicy: restartPolicy,
+			PortBindings:  portBindings,
 		},
 	})
 	if err != nil {
@@ -78,20 +125,39 @@ func resizeExecTTY(client *dockerClient.Client, ctx context.Context, execID stri
 	return err
 }
 
-func (d *DevClient) Exec(containerName string) error {
+// Options for a session in a running container.
+type ExecOptions struct {
+	Env []string
+	// directory in the container to start in. Defaults to the image's WORKDIR.
+	WorkingDir string
+	// key sequence that detaches from an interactive session, leaving it
+	// running. Defaults to docker's (ctrl-p,ctrl-q).
+	DetachKeys string
+	// command Exec runs on the TTY. Defaults to a login shell.
+	Cmd []string
+}
+
+func (d *DevClient) Exec(containerName string, opts ExecOptions) error {
 	u, err := user.Current()
 	if err != nil {
 		return err
 	}
 
 	userSpec := fmt.Sprintf("%s:%s", u.Uid, u.Gid)
+	cmd := opts.Cmd
+	if len(cmd) == 0 {
+		cmd = []string{"/bin/bash", "-l"}
+	}
 
 	execResp, err := d.client.ExecCreate(
 		d.ctx,
 		containerName,
 		dockerClient.ExecCreateOptions{
 			User:         userSpec,
-			Cmd:          []string{"/bin/bash", "-l"},
+			Env:          opts.Env,
+			WorkingDir:   opts.WorkingDir,
+			Cmd:          cmd,
+			DetachKeys:   opts.DetachKeys,
 			TTY:          true,
 			AttachStdin:  true,
 			AttachStdout: true,
@@ -144,24 +210,65 @@ func (d *DevClient) Exec(containerName string) error {
 
 	// pipe container → stdout
 	_, err = io.Copy(os.Stdout, attachResp.Reader)
+	if err != nil {
+		return err
+	}
 
-	return err
+	// the stream also ends when the detach keys are pressed, in which case
+	// the session carries on without us.
+	inspectResp, err := d.client.ExecInspect(d.ctx, execResp.ID, dockerClient.ExecInspectOptions{})
+	if err == nil && inspectResp.Running {
+		term.Restore(fd, oldState)
+		fmt.Fprintln(os.Stderr, "\nDetached; the session is still running in the container.")
+	}
+	return nil
+}
+
+// Returned by ExecCmd when the command exits with a nonzero status.
+type ExitError struct {
+	Code int
 }
 
-func (d *DevClient) ExecCmd(containerName string, cmd []string) error {
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// whether stdin is a pipe or file rather than a terminal or /dev/null.
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// Runs cmd in the container without a TTY. Stdin is forwarded when it is
+// piped, and the command's stdout and stderr go to ours. SIGINT, SIGTERM and
+// SIGHUP are forwarded to the command's process group, and the command is
+// killed if we return before it exits. Returns an *ExitError carrying the
+// exact status if the command exits nonzero.
+func (d *DevClient) ExecCmd(containerName string, cmd []string, opts ExecOptions) error {
 	u, err := user.Current()
 	if err != nil {
 		return err
 	}
 
 	userSpec := fmt.Sprintf("%s:%s", u.Uid, u.Gid)
+	attachStdin := stdinIsPiped()
+	wrapped, pidfile, err := wrapExecCmd(cmd)
+	if err != nil {
+		return err
+	}
 
 	execResp, err := d.client.ExecCreate(
 		d.ctx,
 		containerName,
 		dockerClient.ExecCreateOptions{
 			User:         userSpec,
-			Cmd:          cmd,
+			Env:          opts.Env,
+			WorkingDir:   opts.WorkingDir,
+			Cmd:          wrapped,
+			AttachStdin:  attachStdin,
 			AttachStdout: true,
 			AttachStderr: true,
 		},
@@ -170,6 +277,11 @@ func (d *DevClient) ExecCmd(containerName string, cmd []string) error {
 		return err
 	}
 
+	// from here on, signals are the command's to handle.
+	sigCh := make(chan os.Signal, 4)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
 	attachResp, err := d.client.ExecAttach(
 		d.ctx,
 		execResp.ID,
@@ -180,20 +292,107 @@ func (d *DevClient) ExecCmd(containerName string, cmd []string) error {
 	}
 	defer attachResp.Close()
 
-	_, err = io.Copy(os.Stdout, attachResp.Reader)
+	exited := false
+	defer func() {
+		if !exited {
+			_ = d.signalExec(containerName, userSpec, pidfile, syscall.SIGKILL)
+		}
+	}()
+
+	if attachStdin {
+		go func() {
+			_, _ = io.Copy(attachResp.Conn, os.Stdin)
+			// let the command see EOF.
+			_ = attachResp.CloseWrite()
+		}()
+	}
+
+	// without a TTY, stdout and stderr are multiplexed on one stream.
+	copyErr := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
+		copyErr <- err
+	}()
+
+	interrupts := 0
+	for done := false; !done; {
+		select {
+		case err := <-copyErr:
+			if err != nil {
+				return err
+			}
+			done = true
+		case sig := <-sigCh:
+			// a second ^C means the command isn't listening; stop waiting.
+			if sig == syscall.SIGINT {
+				interrupts++
+				if interrupts > 1 {
+					return &ExitError{Code: 130}
+				}
+			}
+			_ = d.signalExec(containerName, userSpec, pidfile, sig.(syscall.Signal))
+		}
+	}
+
+	// the stream can end slightly before the daemon records the exit code.
+	for {
+		inspectResp, err := d.client.ExecInspect(d.ctx, execResp.ID, dockerClient.ExecInspectOptions{})
+		if err != nil {
+			return err
+		}
+		if inspectResp.Running {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		exited = true
+		if inspectResp.ExitCode != 0 {
+			return &ExitError{Code: inspectResp.ExitCode}
+		}
+		return nil
+	}
+}
+
+// Runs cmd in the container as the dev user and returns its stdout. A
+// nonzero exit is an *ExitError, which output comes with anyway.
+func (d *DevClient) ExecOutput(containerName string, cmd []string) ([]byte, error) {
+	u, err := user.Current()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	inspectResp, err := d.client.ExecInspect(d.ctx, execResp.ID, dockerClient.ExecInspectOptions{})
+	execResp, err := d.client.ExecCreate(d.ctx, containerName, dockerClient.ExecCreateOptions{
+		User:         fmt.Sprintf("%s:%s", u.Uid, u.Gid),
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if inspectResp.ExitCode != 0 {
-		return fmt.Errorf("command exited with status %d", inspectResp.ExitCode)
+	attachResp, err := d.client.ExecAttach(d.ctx, execResp.ID, dockerClient.ExecAttachOptions{})
+	if err != nil {
+		return nil, err
 	}
+	defer attachResp.Close()
 
-	return nil
+	var stdout bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, io.Discard, attachResp.Reader); err != nil {
+		return nil, err
+	}
+	for {
+		inspectResp, err := d.client.ExecInspect(d.ctx, execResp.ID, dockerClient.ExecInspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if inspectResp.Running {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if inspectResp.ExitCode != 0 {
+			return stdout.Bytes(), &ExitError{Code: inspectResp.ExitCode}
+		}
+		return stdout.Bytes(), nil
+	}
 }
 
 func (d *DevClient) Delete(containerName string) error {
@@ -210,17 +409,53 @@ func (d *DevClient) Delete(containerName string) error {
 	return err
 }
 
-func (d *DevClient) ContainerExists(containerName string) (bool, error) {
+// Returns the host config of a container, which holds the resource limits it
+// is actually running with.
+func (d *DevClient) HostConfig(containerName string) (*container.HostConfig, error) {
+	result, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Container.HostConfig, nil
+}
+
+// Returns the state of a container, or "" if there is no such container.
+func (d *DevClient) ContainerState(containerName string) (container.ContainerState, error) {
 	result, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
 	if err != nil {
 		if errdefs.IsNotFound(err) {
-			return false, nil
+			return "", nil
 		}
-		return false, err
+		return "", err
+	}
+	if result.Container.State == nil {
+		return "", nil
 	}
-	if !result.Container.State.Running {
-		d.Delete(containerName)
-		return false, nil
+	return result.Container.State.Status, nil
+}
+
+// Brings a stopped or paused container back up without losing anything
+// written to it.
+func (d *DevClient) Resume(containerName string) error {
+	state, err := d.ContainerState(containerName)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case container.StatePaused:
+		_, err = d.client.ContainerUnpause(d.ctx, containerName, dockerClient.ContainerUnpauseOptions{})
+	case container.StateRunning, container.StateRestarting:
+	default:
+		_, err = d.client.ContainerStart(d.ctx, containerName, dockerClient.ContainerStartOptions{})
+	}
+	return err
+}
+
+// Stops a container, keeping it around to be resumed later.
+func (d *DevClient) Stop(containerName string) error {
+	_, err := d.client.ContainerStop(d.ctx, containerName, dockerClient.ContainerStopOptions{})
+	if errdefs.IsNotFound(err) {
+		return nil
 	}
-	return true, nil
+	return err
 }
@@ -1,18 +1,93 @@
 package cli
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/distribution/reference"
 	"github.com/junikimm717/dev106/internal/shared"
+	"github.com/moby/moby/api/types/container"
 )
 
+// name of the per-repository config file that lives at the repository root.
+const repoConfigName = ".dev106.toml"
+
+// source recorded for keys that were never set by any file.
+const defaultSource = "(default)"
+
+// the image written by `dev106 config init` when none is chosen.
+const DefaultImage = "ghcr.io/junikimm717/dev106/nvim:2.1.0"
+
+var (
+	ErrNoConfig      = errors.New("config file does not exist")
+	ErrImageRequired = errors.New("image is required")
+)
+
+// An error in a particular config file. Path is empty for errors in the
+// merged config that can't be pinned to a single file.
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return "config: " + e.Err.Error()
+	}
+	return fmt.Sprintf("config %s: %s", e.Path, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
 type DevConfig struct {
-	Telerun bool   `toml:"telerun"`
-	Image   string `toml:"image"`
+	Telerun        bool   `toml:"telerun"`
+	Image          string `toml:"image"`
+	Platform       string `toml:"platform,omitempty"`
+	PullPolicy     string `toml:"pull_policy,omitempty"`
+	RestartPolicy  string `toml:"restart_policy,omitempty"`
+	ReadyTimeout   string `toml:"ready_timeout,omitempty"`
+	DetachKeys     string `toml:"detach_keys,omitempty"`
+	UpdateCheck    bool   `toml:"update_check,omitempty"`
+	SSHAgent       bool   `toml:"ssh_agent,omitempty"`
+	GitIdentity    bool   `toml:"git_identity,omitempty"`
+	GitCredentials bool   `toml:"git_credentials,omitempty"`
+	DefaultProfile string `toml:"default_profile,omitempty"`
+
+	Mounts []MountConfig `toml:"mounts,omitempty"`
+	Ports  []string      `toml:"ports,omitempty"`
+
+	Env         map[string]string `toml:"env,omitempty"`
+	Passthrough []string          `toml:"passthrough,omitempty"`
+	EnvFile     string            `toml:"env_file,omitempty"`
+
+	ResourceConfig
+
+	Build *BuildConfig `toml:"build,omitempty"`
+
+	// name of the profile that was applied, if any. Not part of the file format.
+	Profile string `toml:"-"`
+	// file each top-level key was last set from. Not part of the file format.
+	Sources map[string]string `toml:"-"`
+}
+
+// a decoded config file. Profiles are kept undecoded until we know which one
+// (if any) is selected, since each one may override any DevConfig key.
+type configLayer struct {
+	path     string
+	contents []byte
+	md       toml.MetaData
+	profiles map[string]toml.Primitive
 }
 
 func configDir() (string, error) {
@@ -28,7 +103,8 @@ func configDir() (string, error) {
 	return filepath.Join(home, ".config", shared.APPNAME), nil
 }
 
-func configPath() (string, error) {
+// path of the global config file.
+func ConfigPath() (string, error) {
 	dir, err := configDir()
 	if err != nil {
 		return "", err
@@ -36,52 +112,445 @@ func configPath() (string, error) {
 	return filepath.Join(dir, "config.toml"), nil
 }
 
-// TODO: update the default image!
-func defaultConfigContents() string {
+// path of the per-repository overlay for the repository at root.
+func RepoConfigPath(root string) string {
+	return filepath.Join(root, repoConfigName)
+}
+
+func defaultConfigContents(image string) string {
 	return `# dev106 configuration
 # Required:
-image = "ghcr.io/junikimm717/dev106/nvim:2.1.0"
+image = "` + image + `"
 
 # Optional (defaults to true):
 telerun = true
+
+# Optional: os/arch[/variant] to run the image as. Defaults to the host's
+# platform when the image provides it.
+# platform = "linux/amd64"
+
+# Optional: when to pull the image before creating a container: "missing"
+# (the default) pulls it only if it isn't available locally.
+# pull_policy = "missing"
+
+# Optional: docker restart policy (no, always, unless-stopped or
+# on-failure[:max-retries]). With "unless-stopped", containers come back after
+# the docker daemon restarts unless you ran dev106 stop.
+# restart_policy = "unless-stopped"
+
+# Optional: how long to wait for the container to finish bootstrapping.
+# ready_timeout = "2m"
+
+# Optional: keys that detach from a shell, leaving it running in the
+# container. Uses docker's format.
+# detach_keys = "ctrl-p,ctrl-q"
+
+# Optional: make the host's ssh agent available in every session, e.g. to push
+# to GitHub over ssh without copying keys into the container.
+# ssh_agent = true
+
+# Optional: commit as your host git user.name and user.email, and answer git's
+# credential requests (e.g. for https remotes) with the host's credential
+# helpers, so no secrets have to be copied into the container.
+# git_identity = true
+# git_credentials = true
+
+# Optional: check the registry for a newer image (at most once a day) when
+# opening a shell.
+# update_check = true
+
+# Optional: profiles override any of the keys above. Select one with
+# --profile, or set default_profile.
+# default_profile = "cilk4"
+#
+# [profiles.cilk4]
+# image = "ghcr.io/junikimm717/dev106/nvim:4.0-rc1"
+
+# Optional: extra mounts. type is one of bind, volume or tmpfs. Sources may
+# use ~ and ${repo_root}.
+# [[mounts]]
+# type = "volume"
+# source = "dev106-cache"
+# target = "/home/dev106/.cache"
+# read_only = false
+
+# Optional: ports to publish on the host, in docker's -p format. Use
+# dev106 port forward to reach a port without recreating the container.
+# ports = ["127.0.0.1:8888:8888", "1234"]
+
+# Optional: environment for the container and every exec session. Host
+# variables in passthrough are copied when set; env_file is relative to the
+# repository root.
+# passthrough = ["HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"]
+# env_file = ".env"
+# [env]
+# OMP_NUM_THREADS = "4"
+
+# Optional: resource limits. DEV_NPROC is set inside the container to the
+# number of CPUs it may use.
+# cpus = 4
+# cpuset = "0-3"
+# memory = "8g"
+# memory_swap = "8g"
+# pids_limit = 4096
+# shm_size = "1g"
+
+# Optional: build the image from a Dockerfile instead of pulling image. The
+# context is relative to the repository root. The image is rebuilt whenever
+# the Dockerfile or anything in its context changes.
+# [build]
+# context = "."
+# dockerfile = "Dockerfile"
+# target = "dev"
+# [build.args]
+# COURSE = "6.106"
 `
 }
 
-func LoadConfig() (*DevConfig, error) {
-	path, err := configPath()
+// Loads the global config and, if root is nonempty and contains a
+// .dev106.toml, layers that file on top of it. Keys in the repository file
+// take precedence over the global file, which takes precedence over defaults.
+// Tables and lists in replacedKeys are replaced as a whole, like any other
+// key.
+// The repository file may only set trustedKeys once the user has trusted it.
+//
+// The selected profile is then applied on top of the merged result. profile
+// overrides default_profile; if both are empty no profile is applied.
+func LoadConfig(root string, profile string) (*DevConfig, error) {
+	path, err := ConfigPath()
 	if err != nil {
 		return nil, err
 	}
 
-	dir := filepath.Dir(path)
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &ConfigError{Path: path, Err: ErrNoConfig}
+		}
+		return nil, err
+	}
 
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	cfg := &DevConfig{
+		Telerun: true, // default
+		Sources: map[string]string{
+			"telerun": defaultSource,
+		},
+	}
+
+	layers := make([]*configLayer, 0, 2)
+	layer, err := cfg.overlay(path)
+	if err != nil {
 		return nil, err
 	}
+	layers = append(layers, layer)
 
-	// If missing → create + exit
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		if err := os.WriteFile(path, []byte(defaultConfigContents()), 0644); err != nil {
+	if root != "" {
+		repoPath := RepoConfigPath(root)
+		_, err := os.Stat(repoPath)
+		if err == nil {
+			layer, err := cfg.overlay(repoPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkTrust(repoPath, layer.contents, layer.md); err != nil {
+				return nil, err
+			}
+			layers = append(layers, layer)
+		} else if !errors.Is(err, os.ErrNotExist) {
 			return nil, err
 		}
+	}
 
-		fmt.Printf("Created config at %s\n", path)
-		fmt.Println("Please edit it and re-run dev106.")
-		os.Exit(0)
+	if profile == "" {
+		profile = cfg.DefaultProfile
+	}
+	if profile != "" {
+		if err := cfg.applyProfile(layers, profile); err != nil {
+			return nil, err
+		}
 	}
 
-	cfg := &DevConfig{
-		Telerun: true, // default
+	if err := cfg.validate(); err != nil {
+		return nil, &ConfigError{Err: err}
 	}
 
-	if _, err := toml.DecodeFile(path, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+	return cfg, nil
+}
+
+// checks the merged config for values that decode fine but can't work.
+func (c *DevConfig) validate() error {
+	// a [build] section makes its own image.
+	if c.Image == "" && c.Build == nil {
+		return ErrImageRequired
+	}
+	if _, _, err := c.Resources(); err != nil {
+		return err
 	}
+	if c.Platform != "" {
+		if _, err := ParsePlatform(c.Platform); err != nil {
+			return err
+		}
+	}
+	switch c.PullPolicy {
+	case "", PullMissing, PullAlways, PullNever:
+	default:
+		return fmt.Errorf(
+			"pull_policy: unknown policy %q (expected %s, %s or %s)",
+			c.PullPolicy, PullMissing, PullAlways, PullNever,
+		)
+	}
+	if _, err := c.ParseRestartPolicy(); err != nil {
+		return err
+	}
+	if _, _, err := c.PortBindings(); err != nil {
+		return err
+	}
+	if _, err := c.ReadyTimeoutDuration(); err != nil {
+		return err
+	}
+	if c.DetachKeys != "" {
+		if err := validateDetachKeys(c.DetachKeys); err != nil {
+			return err
+		}
+	}
+	if c.Build != nil {
+		return nil
+	}
+	return validateImage(c.Image)
+}
 
-	if cfg.Image == "" {
-		return nil, errors.New("config: image is required")
+// Parses restart_policy. An empty policy means "no".
+func (c *DevConfig) ParseRestartPolicy() (container.RestartPolicy, error) {
+	name, retries, hasRetries := strings.Cut(c.RestartPolicy, ":")
+	policy := container.RestartPolicy{
+		Name: container.RestartPolicyMode(name),
+	}
+	if name == "" {
+		policy.Name = container.RestartPolicyDisabled
+	}
+	if hasRetries {
+		n, err := strconv.Atoi(retries)
+		if err != nil {
+			return policy, fmt.Errorf("restart_policy: invalid retry count %q", retries)
+		}
+		policy.MaximumRetryCount = n
 	}
+	switch policy.Name {
+	case container.RestartPolicyDisabled, container.RestartPolicyAlways,
+		container.RestartPolicyUnlessStopped, container.RestartPolicyOnFailure:
+	default:
+		return policy, fmt.Errorf(
+			"restart_policy: unknown policy %q (expected no, always, unless-stopped or on-failure)", name,
+		)
+	}
+	if err := container.ValidateRestartPolicy(policy); err != nil {
+		return policy, fmt.Errorf("restart_policy: %w", err)
+	}
+	return policy, nil
+}
 
-	return cfg, nil
+func validateImage(image string) error {
+	if _, err := reference.ParseNormalizedNamed(image); err != nil {
+		return fmt.Errorf("image %q: %w", image, err)
+	}
+	return nil
+}
+
+// Checks a single config file on its own: every value must have the right
+// type, every key must be known, and images must be valid references. All
+// problems are reported, not just the first.
+func ValidateConfigFile(path string) error {
+	file := struct {
+		DevConfig
+		Profiles map[string]DevConfig `toml:"profiles"`
+	}{}
+
+	md, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return &ConfigError{Path: path, Err: err}
+	}
+
+	var errs []error
+	for _, key := range md.Undecoded() {
+		errs = append(errs, fmt.Errorf("unknown key %s", key))
+	}
+	if file.Image != "" {
+		if err := validateImage(file.Image); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name, profile := range file.Profiles {
+		if profile.Image != "" {
+			if err := validateImage(profile.Image); err != nil {
+				errs = append(errs, fmt.Errorf("profiles.%s: %w", name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &ConfigError{Path: path, Err: errors.Join(errs...)}
+	}
+	return nil
 }
 
+// Writes a fresh global config using image. Refuses to replace an existing
+// file unless force is set.
+func InitConfig(image string, force bool) (string, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	if err := validateImage(image); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(defaultConfigContents(image)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// tables and lists a file replaces as a whole instead of merging key by key,
+// so that e.g. a repository's [build] never inherits args meant for another
+// Dockerfile. The decoder merges tables into existing ones and decodes lists
+// into the existing elements, so a [[mounts]] entry would otherwise inherit
+// fields such as read_only from the entry it lands on.
+var replacedKeys = []string{"env", "build", "mounts", "ports", "passthrough"}
+
+// forgets the tables and lists that md (at prefix, e.g. a profile) sets, so
+// decoding it replaces them.
+func (c *DevConfig) resetReplaced(md toml.MetaData, prefix ...string) {
+	for _, key := range replacedKeys {
+		if !md.IsDefined(slices.Concat(prefix, []string{key})...) {
+			continue
+		}
+		switch key {
+		case "env":
+			c.Env = nil
+		case "build":
+			c.Build = nil
+		case "mounts":
+			c.Mounts = nil
+		case "ports":
+			c.Ports = nil
+		case "passthrough":
+			c.Passthrough = nil
+		}
+	}
+}
+
+// decodes the file at path on top of the current values, recording path as
+// the source of every top-level key that it sets.
+func (c *DevConfig) overlay(path string) (*configLayer, error) {
+	file := struct {
+		*DevConfig
+		Profiles map[string]toml.Primitive `toml:"profiles"`
+	}{DevConfig: c}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// a first pass to find the tables and lists to replace.
+	md, err := toml.Decode(string(data), &struct{}{})
+	if err != nil {
+		return nil, &ConfigError{Path: path, Err: err}
+	}
+	c.resetReplaced(md)
+	md, err = toml.Decode(string(data), &file)
+	if err != nil {
+		return nil, &ConfigError{Path: path, Err: err}
+	}
+	for _, key := range md.Keys() {
+		if len(key) == 1 && key[0] != "profiles" {
+			c.Sources[key[0]] = path
+		}
+	}
+	return &configLayer{
+		path:     path,
+		contents: data,
+		md:       md,
+		profiles: file.Profiles,
+	}, nil
+}
+
+// applies [profiles.<name>] from every layer that defines it, in layer order,
+// so a repository's profile table overrides the global one key by key (and
+// table by table).
+func (c *DevConfig) applyProfile(layers []*configLayer, name string) error {
+	found := false
+	for _, layer := range layers {
+		prim, ok := layer.profiles[name]
+		if !ok {
+			continue
+		}
+		found = true
+		c.resetReplaced(layer.md, "profiles", name)
+		if err := layer.md.PrimitiveDecode(prim, c); err != nil {
+			return &ConfigError{Path: layer.path, Err: fmt.Errorf("profiles.%s: %w", name, err)}
+		}
+		for _, key := range layer.md.Keys() {
+			if len(key) == 3 && key[0] == "profiles" && key[1] == name {
+				c.Sources[key[2]] = fmt.Sprintf("%s [profiles.%s]", layer.path, name)
+			}
+		}
+	}
+	if !found {
+		return &ConfigError{Err: fmt.Errorf("profile %q is not defined", name)}
+	}
+	c.Profile = name
+	return nil
+}
+
+// Writes the merged config as TOML, with each top-level key annotated with
+// the file it came from.
+func (c *DevConfig) WriteResolved(w io.Writer) error {
+	var buf bytes.Buffer
+	if c.Profile != "" {
+		fmt.Fprintf(&buf, "# profile: %s\n", c.Profile)
+	}
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(c.Sources))
+	width := 0
+	for key := range c.Sources {
+		keys = append(keys, key)
+		width = max(width, len(key))
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "# Sources:")
+	for _, key := range keys {
+		fmt.Fprintf(w, "#   %-*s  %s\n", width, key, c.Sources[key])
+	}
+	return nil
+}
+
+// Returns the contents of the global config file.
+func ReadGlobalConfig() ([]byte, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writes the global config and a trusted .dev106.toml in a new repository,
+// returning the repository root.
+func writeLayers(t *testing.T, global string, repo string) string {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	path, err := ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(global), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	repoPath := RepoConfigPath(root)
+	if err := os.WriteFile(repoPath, []byte(repo), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TrustRepoConfig(repoPath, []byte(repo)); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestLoadConfigReplacesLists(t *testing.T) {
+	root := writeLayers(t, `
+image = "global"
+ports = ["8000"]
+passthrough = ["EDITOR", "PAGER"]
+
+[[mounts]]
+type = "bind"
+source = "/tmp"
+target = "/x"
+read_only = true
+
+[[mounts]]
+type = "volume"
+source = "cache"
+target = "/cache"
+
+[profiles.big]
+ports = ["9000"]
+
+[[profiles.big.mounts]]
+type = "bind"
+source = "/srv"
+target = "/srv"
+read_only = true
+`, `
+passthrough = ["TERM"]
+
+[[mounts]]
+type = "tmpfs"
+target = "/z"
+`)
+
+	tests := []struct {
+		profile     string
+		mounts      []MountConfig
+		ports       []string
+		passthrough []string
+	}{
+		{
+			profile:     "",
+			mounts:      []MountConfig{{Type: "tmpfs", Target: "/z"}},
+			ports:       []string{"8000"},
+			passthrough: []string{"TERM"},
+		},
+		{
+			profile:     "big",
+			mounts:      []MountConfig{{Type: "bind", Source: "/srv", Target: "/srv", ReadOnly: true}},
+			ports:       []string{"9000"},
+			passthrough: []string{"TERM"},
+		},
+	}
+	for _, tt := range tests {
+		config, err := LoadConfig(root, tt.profile)
+		if err != nil {
+			t.Fatalf("profile %q: %v", tt.profile, err)
+		}
+		if !reflect.DeepEqual(config.Mounts, tt.mounts) {
+			t.Errorf("profile %q: mounts = %+v, want %+v", tt.profile, config.Mounts, tt.mounts)
+		}
+		if !reflect.DeepEqual(config.Ports, tt.ports) {
+			t.Errorf("profile %q: ports = %v, want %v", tt.profile, config.Ports, tt.ports)
+		}
+		if !reflect.DeepEqual(config.Passthrough, tt.passthrough) {
+			t.Errorf("profile %q: passthrough = %v, want %v", tt.profile, config.Passthrough, tt.passthrough)
+		}
+		if _, err := ConfigMounts(config, root); err != nil {
+			t.Errorf("profile %q: ConfigMounts: %v", tt.profile, err)
+		}
+	}
+}
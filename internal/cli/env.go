@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Parses a .env file. Blank lines and lines starting with # are skipped, an
+// optional leading "export " is allowed, and values may be wrapped in single
+// or double quotes.
+func parseEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineno)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 {
+			if (value[0] == '"' && value[len(value)-1] == '"') ||
+				(value[0] == '\'' && value[len(value)-1] == '\'') {
+				value = value[1 : len(value)-1]
+			}
+		}
+		res[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Computes the extra environment for a container and its exec sessions, as a
+// sorted list of KEY=VALUE. Later sources win: env_file, then [env], then
+// passthrough variables that are set on the host.
+func Environment(config *DevConfig, root string) ([]string, error) {
+	vars := make(map[string]string)
+
+	if config.EnvFile != "" && root != "" {
+		path, err := expandSource(config.EnvFile, root)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		fileVars, err := parseEnvFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("env_file: %w", err)
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+
+	for k, v := range config.Env {
+		vars[k] = v
+	}
+
+	for _, name := range config.Passthrough {
+		if v, ok := os.LookupEnv(name); ok {
+			vars[name] = v
+		}
+	}
+
+	res := make([]string, 0, len(vars))
+	for k, v := range vars {
+		res = append(res, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -8,7 +8,9 @@ import (
 	"io/fs"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/junikimm717/dev106/internal/shared"
 )
@@ -39,16 +41,37 @@ func FindRoot(dir string) (string, error) {
 	}
 }
 
-func ContainerName(dir string) string {
+// name of the container for the repository at dir. Containers for different
+// profiles of the same repository get different names so they can coexist.
+func ContainerName(dir string, profile string) string {
 	u, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
-	sum := sha256.Sum256([]byte(dir))
+	key := dir
+	if profile != "" {
+		key = dir + "\x00" + profile
+	}
+	sum := sha256.Sum256([]byte(key))
 	id := hex.EncodeToString(sum[:])[:12]
 	return fmt.Sprintf("%s_%s_%s", shared.CONTAINER_PREFIX, u.Username, id)
 }
 
+// Path in the container that corresponds to dir on the host, for the
+// repository at root. Directories outside the repository map to the
+// workspace itself.
+func ContainerWorkDir(root string, dir string) string {
+	realpath, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return shared.CONTAINER_WORKSPACE
+	}
+	rel, err := filepath.Rel(root, realpath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return shared.CONTAINER_WORKSPACE
+	}
+	return path.Join(shared.CONTAINER_WORKSPACE, filepath.ToSlash(rel))
+}
+
 // compute the bind mounts that we'll need for a container.
 func BindMounts(config *DevConfig, dir string) ([]string, error) {
 	res := make([]string, 0, 2)
@@ -77,7 +100,7 @@ func BindMounts(config *DevConfig, dir string) ([]string, error) {
 	if !stat.IsDir() {
 		return res, fmt.Errorf("%s is not a directory!", dir)
 	}
-	res = append(res, fmt.Sprintf("%s:%s:rw", dir, "/workspace"))
+	res = append(res, fmt.Sprintf("%s:%s:rw", dir, shared.CONTAINER_WORKSPACE))
 
 	// telerun credentials should be synced.
 	telerun := filepath.Join(home, ".telerun")
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/junikimm717/dev106/internal/shared"
+)
+
+// git credential subcommands run on the host for each helper action.
+var credentialActions = map[string]string{
+	"get":   "fill",
+	"store": "approve",
+	"erase": "reject",
+}
+
+// value of key in the host's git config as seen from dir, or "" if it isn't
+// set or git isn't installed.
+func hostGitConfig(dir string, key string) string {
+	cmd := exec.Command("git", "config", "--get", key)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// quotes a value for a git config file.
+func gitConfigValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+// Writes the git config sessions use as their system config: the host's
+// identity for the repository at root, /workspace marked safe, and helper
+// (if any) as the credential helper. The image's /etc/gitconfig is still
+// included, and the user's ~/.gitconfig in the container overrides all of it.
+func writeGitConfig(dir string, root string, config *DevConfig, helper string) error {
+	var b strings.Builder
+	b.WriteString("# Generated by dev106 for each session; changes are overwritten.\n")
+	b.WriteString("[include]\n\tpath = /etc/gitconfig\n")
+	b.WriteString("[safe]\n\tdirectory = " + gitConfigValue(shared.CONTAINER_WORKSPACE) + "\n")
+	if config.GitIdentity {
+		name := hostGitConfig(root, "user.name")
+		email := hostGitConfig(root, "user.email")
+		if name != "" || email != "" {
+			b.WriteString("[user]\n")
+		}
+		if name != "" {
+			b.WriteString("\tname = " + gitConfigValue(name) + "\n")
+		}
+		if email != "" {
+			b.WriteString("\temail = " + gitConfigValue(email) + "\n")
+		}
+	}
+	if helper != "" {
+		b.WriteString("[credential]\n\thelper = " + gitConfigValue(helper) + "\n")
+	}
+
+	return writeSessionFile(dir, "gitconfig", []byte(b.String()), 0o644)
+}
+
+// Sets up git for the session: the generated config, pointing git at the
+// relay's credential socket if it should answer credential requests with the
+// host's credential helpers. Also returns whether the socket is needed.
+func forwardGit(dir string, root string, config *DevConfig, entrypoint []string) ([]string, bool, error) {
+	var helper string
+	if config.GitCredentials {
+		if len(entrypoint) == 0 {
+			fmt.Fprintln(os.Stderr, "Warning: git_credentials needs an image that runs the dev106 bootstrapper; not forwarding credentials")
+		} else {
+			helper = entrypoint[0] + " " + shared.GIT_CREDENTIAL_HELPER_ARG
+		}
+	}
+	if err := writeGitConfig(dir, root, config, helper); err != nil {
+		return nil, false, fmt.Errorf("git: %w", err)
+	}
+	env := []string{"GIT_CONFIG_SYSTEM=" + shared.GIT_CONFIG}
+	if helper == "" {
+		return env, false, nil
+	}
+	env = append(env, shared.GIT_CREDENTIAL_SOCK_ENV+"="+path.Join(shared.SESSION_DIR, credentialSocket))
+	return env, true, nil
+}
+
+// Reads a request from the credential helper in the container: the action,
+// then the attributes git gave the helper. The host's git answers it, without
+// prompting, since the terminal belongs to the session.
+func answerCredentialRequest(conn net.Conn, root string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	action, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	subcommand, ok := credentialActions[strings.TrimSpace(action)]
+	if !ok {
+		return
+	}
+	input, err := io.ReadAll(reader)
+	if err != nil {
+		return
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("git", "credential", subcommand)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return
+	}
+	if subcommand == "fill" {
+		_, _ = conn.Write(out.Bytes())
+	}
+}
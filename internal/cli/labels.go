@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/user"
+	"runtime/debug"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	dockerClient "github.com/moby/moby/client"
+)
+
+// labels dev106 sets on the containers it creates.
+const (
+	// name of the host user that owns the container.
+	LabelUser = "dev106.user"
+	// absolute path of the repository root mounted at /workspace.
+	LabelRepo = "dev106.repo"
+	// config profile the container was created with, if any.
+	LabelProfile = "dev106.profile"
+	// image reference from the config, and the ID it resolved to.
+	LabelImage       = "dev106.image"
+	LabelImageDigest = "dev106.image.digest"
+	// hash of the parts of the resolved config the container was created
+	// from.
+	LabelConfigHash = "dev106.config.hash"
+	// version of the CLI that created the container.
+	LabelVersion = "dev106.version"
+	// creation time, RFC 3339.
+	LabelCreated = "dev106.created"
+	// platform the container was created for, as os/arch[/variant].
+	LabelPlatform = "dev106.platform"
+)
+
+// Version of this build of the CLI, as recorded by go install.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// the parts of the config that end up in ContainerCreate. The image is
+// compared separately, and everything else (detach_keys, ready_timeout, ...)
+// only affects the CLI, so changing it doesn't make a container out of date.
+type createConfig struct {
+	Telerun        bool              `toml:"telerun"`
+	Platform       string            `toml:"platform"`
+	RestartPolicy  string            `toml:"restart_policy"`
+	SSHAgent       bool              `toml:"ssh_agent"`
+	GitIdentity    bool              `toml:"git_identity"`
+	GitCredentials bool              `toml:"git_credentials"`
+	Mounts         []MountConfig     `toml:"mounts"`
+	Ports          []string          `toml:"ports"`
+	Env            map[string]string `toml:"env"`
+	Passthrough    []string          `toml:"passthrough"`
+	EnvFile        string            `toml:"env_file"`
+	ResourceConfig
+}
+
+// Hash of what a container created from the config on platform looks like,
+// used to notice that a container was created from a different config than
+// the current one.
+func (c *DevConfig) Hash(platform string) string {
+	sum := sha256.New()
+	err := toml.NewEncoder(sum).Encode(createConfig{
+		Telerun:        c.Telerun,
+		Platform:       platform,
+		RestartPolicy:  c.RestartPolicy,
+		SSHAgent:       c.SSHAgent,
+		GitIdentity:    c.GitIdentity,
+		GitCredentials: c.GitCredentials,
+		Mounts:         c.Mounts,
+		Ports:          c.Ports,
+		Env:            c.Env,
+		Passthrough:    c.Passthrough,
+		EnvFile:        c.EnvFile,
+		ResourceConfig: c.ResourceConfig,
+	})
+	if err != nil {
+		// only fails for types that can't be encoded, which createConfig has none of.
+		panic(err)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// labels for a new container for the repository at root, running imageID.
+func containerLabels(config *DevConfig, root string, imageID string, platform string) (map[string]string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		LabelUser:        u.Username,
+		LabelRepo:        root,
+		LabelProfile:     config.Profile,
+		LabelImage:       config.Image,
+		LabelImageDigest: imageID,
+		LabelConfigHash:  config.Hash(platform),
+		LabelVersion:     Version(),
+		LabelCreated:     time.Now().UTC().Format(time.RFC3339),
+		LabelPlatform:    platform,
+	}, nil
+}
+
+// Returns the labels of a container.
+func (d *DevClient) ContainerLabels(containerName string) (map[string]string, error) {
+	result, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if result.Container.Config == nil {
+		return map[string]string{}, nil
+	}
+	return result.Container.Config.Labels, nil
+}
+
+// Reasons the container no longer matches what Run would create now: the
+// config has changed or the image reference now points at a different image.
+// Containers created before dev106 labelled its containers are never stale,
+// since there is nothing to compare against.
+func (d *DevClient) StaleReasons(containerName string, config *DevConfig) ([]string, error) {
+	result, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if result.Container.Config == nil {
+		return nil, nil
+	}
+	labels := result.Container.Config.Labels
+	hash, ok := labels[LabelConfigHash]
+	if !ok {
+		return nil, nil
+	}
+
+	var reasons []string
+	if labels[LabelImage] != config.Image {
+		reasons = append(reasons, fmt.Sprintf(
+			"the configured image changed from %s to %s", labels[LabelImage], config.Image,
+		))
+	} else if image, err := d.client.ImageInspect(d.ctx, config.Image); err == nil && image.ID != result.Container.Image {
+		reasons = append(reasons, fmt.Sprintf(
+			"%s has been updated since the container was created", config.Image,
+		))
+	}
+	// without a platform in the config, the container keeps the one it was
+	// created for; resolving it again may pick another without anything
+	// having changed.
+	platform := labels[LabelPlatform]
+	if config.Platform != "" {
+		p, err := ParsePlatform(config.Platform)
+		if err != nil {
+			return nil, err
+		}
+		platform = FormatPlatform(p)
+	}
+	if platform != labels[LabelPlatform] {
+		reasons = append(reasons, fmt.Sprintf(
+			"the platform changed from %s to %s", labels[LabelPlatform], platform,
+		))
+	} else if hash != config.Hash(platform) {
+		reasons = append(reasons, "the config has changed since the container was created")
+	}
+	return reasons, nil
+}
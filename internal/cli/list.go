@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/junikimm717/dev106/internal/shared"
+	dockerClient "github.com/moby/moby/client"
+)
+
+// A dev106 container as shown by `dev106 ls`.
+type ContainerInfo struct {
+	Name       string    `json:"name"`
+	Repo       string    `json:"repo"`
+	Profile    string    `json:"profile,omitempty"`
+	State      string    `json:"state"`
+	Image      string    `json:"image"`
+	ImageID    string    `json:"image_id"`
+	Platform   string    `json:"platform,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Created    time.Time `json:"created"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	// the configured image now refers to a different image than the one the
+	// container runs.
+	Outdated bool `json:"outdated"`
+	// labels the container was created with.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Returns how long the container has been running, or 0 if it isn't.
+func (c *ContainerInfo) Uptime() time.Duration {
+	if c.State != "running" || c.StartedAt.IsZero() {
+		return 0
+	}
+	return time.Since(c.StartedAt)
+}
+
+// Lists every dev106 container belonging to the current user, including
+// stopped ones. Containers created before dev106 labelled its containers are
+// included too, with whatever can't be recovered left empty.
+func (d *DevClient) ListContainers() ([]*ContainerInfo, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("%s_%s_", shared.CONTAINER_PREFIX, u.Username)
+
+	list, err := d.client.ContainerList(d.ctx, dockerClient.ContainerListOptions{
+		All:     true,
+		Filters: make(dockerClient.Filters).Add("name", prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// current image ID for every image reference, to tell what's out of date.
+	imageIDs := make(map[string]string)
+	currentImage := func(ref string) string {
+		if id, ok := imageIDs[ref]; ok {
+			return id
+		}
+		image, err := d.client.ImageInspect(d.ctx, ref)
+		if err != nil {
+			imageIDs[ref] = ""
+		} else {
+			imageIDs[ref] = image.ID
+		}
+		return imageIDs[ref]
+	}
+
+	res := make([]*ContainerInfo, 0, len(list.Items))
+	for _, summary := range list.Items {
+		if len(summary.Names) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(summary.Names[0], "/")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		labels := summary.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		info := &ContainerInfo{
+			Name:     name,
+			Repo:     labels[LabelRepo],
+			Profile:  labels[LabelProfile],
+			State:    string(summary.State),
+			Image:    labels[LabelImage],
+			ImageID:  summary.ImageID,
+			Platform: labels[LabelPlatform],
+			Version:  labels[LabelVersion],
+			Created:  time.Unix(summary.Created, 0),
+			Labels:   labels,
+		}
+		if info.Image == "" {
+			info.Image = summary.Image
+		}
+		if current := currentImage(info.Image); current != "" {
+			info.Outdated = current != summary.ImageID
+		}
+
+		inspect, err := d.client.ContainerInspect(d.ctx, summary.ID, dockerClient.ContainerInspectOptions{})
+		if err == nil && inspect.Container.State != nil {
+			// unset times come back as the zero time, which we leave unset.
+			started, err := time.Parse(time.RFC3339Nano, inspect.Container.State.StartedAt)
+			if err == nil && started.Year() > 1 {
+				info.StartedAt = started
+			}
+			finished, err := time.Parse(time.RFC3339Nano, inspect.Container.State.FinishedAt)
+			if err == nil && finished.Year() > 1 {
+				info.FinishedAt = finished
+			}
+		}
+
+		res = append(res, info)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Repo != res[j].Repo {
+			return res[i].Repo < res[j].Repo
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/junikimm717/dev106/internal/shared"
+	"github.com/moby/moby/api/pkg/stdcopy"
+	dockerClient "github.com/moby/moby/client"
+)
+
+type LogOptions struct {
+	Follow bool
+	// a timestamp or a duration relative to now, like docker logs --since.
+	Since string
+	// number of lines from the end to show, or "all".
+	Tail string
+}
+
+// Streams a container's logs, with its stdout and stderr going to the
+// matching writers.
+func (d *DevClient) Logs(containerName string, opts LogOptions, stdout, stderr io.Writer) error {
+	tail := opts.Tail
+	if tail == "" {
+		tail = "all"
+	}
+	logs, err := d.client.ContainerLogs(d.ctx, containerName, dockerClient.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Since:      opts.Since,
+		Tail:       tail,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	// dev106 containers never have a TTY, so the stream is always multiplexed.
+	_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	return err
+}
+
+// A writer that passes log output through line by line, marking the lines
+// the bootstrapper logged for failures and warnings.
+type LogHighlighter struct {
+	w     io.Writer
+	color bool
+	buf   []byte
+}
+
+// Marks lines written to w with color, or with a textual marker if color is
+// false.
+func NewLogHighlighter(w io.Writer, color bool) *LogHighlighter {
+	return &LogHighlighter{w: w, color: color}
+}
+
+func (h *LogHighlighter) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	for {
+		i := bytes.IndexByte(h.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := h.writeLine(string(h.buf[:i+1])); err != nil {
+			return len(p), err
+		}
+		h.buf = h.buf[i+1:]
+	}
+}
+
+// writes out a trailing partial line, if any.
+func (h *LogHighlighter) Flush() error {
+	if len(h.buf) == 0 {
+		return nil
+	}
+	line := string(h.buf) + "\n"
+	h.buf = nil
+	return h.writeLine(line)
+}
+
+func (h *LogHighlighter) writeLine(line string) error {
+	var marker, color string
+	switch {
+	case strings.Contains(line, shared.LOG_ERROR_PREFIX):
+		marker, color = "!! ", "\033[1;31m"
+	case strings.Contains(line, shared.LOG_WARNING_PREFIX):
+		marker, color = "!  ", "\033[33m"
+	}
+	switch {
+	case marker == "":
+	case h.color:
+		line = color + strings.TrimSuffix(line, "\n") + "\033[0m\n"
+	default:
+		line = marker + line
+	}
+	_, err := io.WriteString(h.w, line)
+	return err
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/junikimm717/dev106/internal/shared"
+	"github.com/moby/moby/api/types/mount"
+)
+
+// a [[mounts]] entry in the config.
+type MountConfig struct {
+	Type     string `toml:"type"`
+	Source   string `toml:"source,omitempty"`
+	Target   string `toml:"target"`
+	ReadOnly bool   `toml:"read_only"`
+}
+
+// same rule the docker daemon applies to volume names.
+var volumeNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// expands a leading ~ to the home directory and ${repo_root} to root.
+func expandSource(source string, root string) (string, error) {
+	if source == "~" || strings.HasPrefix(source, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		source = home + source[1:]
+	}
+	return strings.ReplaceAll(source, "${repo_root}", root), nil
+}
+
+// Validates the [[mounts]] entries in the config and converts them to mounts
+// for the repository at root. Errors name the offending entry so they can be
+// fixed without having to decode a daemon error.
+func ConfigMounts(config *DevConfig, root string) ([]mount.Mount, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]mount.Mount, 0, len(config.Mounts))
+	targets := map[string]bool{
+		shared.CONTAINER_WORKSPACE: true,
+	}
+
+	for i, m := range config.Mounts {
+		where := fmt.Sprintf("mounts[%d]", i)
+
+		if m.Target == "" {
+			return nil, fmt.Errorf("%s: target is required", where)
+		}
+		if !strings.HasPrefix(m.Target, "/") {
+			return nil, fmt.Errorf("%s: target %s is not an absolute path!", where, m.Target)
+		}
+		target := filepath.Clean(m.Target)
+		if targets[target] {
+			return nil, fmt.Errorf("%s: %s is already mounted", where, target)
+		}
+		targets[target] = true
+
+		source, err := expandSource(m.Source, root)
+		if err != nil {
+			return nil, err
+		}
+
+		switch mount.Type(m.Type) {
+		case mount.TypeBind, "":
+			if source == "" {
+				return nil, fmt.Errorf("%s: bind mounts require a source", where)
+			}
+			if !filepath.IsAbs(source) {
+				return nil, fmt.Errorf("%s: %s is not an absolute path!", where, source)
+			}
+			if _, err := os.Stat(source); err != nil {
+				return nil, fmt.Errorf("%s: %w", where, err)
+			}
+			res = append(res, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   filepath.Clean(source),
+				Target:   target,
+				ReadOnly: m.ReadOnly,
+			})
+		case mount.TypeVolume:
+			if !volumeNameRe.MatchString(source) {
+				return nil, fmt.Errorf("%s: %q is not a valid volume name", where, source)
+			}
+			res = append(res, mount.Mount{
+				Type:     mount.TypeVolume,
+				Source:   source,
+				Target:   target,
+				ReadOnly: m.ReadOnly,
+				// only applied if the volume doesn't exist yet. Lets
+				// `dev106 prune` tell which volumes are ours.
+				VolumeOptions: &mount.VolumeOptions{
+					Labels: map[string]string{
+						LabelUser: u.Username,
+					},
+				},
+			})
+		case mount.TypeTmpfs:
+			if source != "" {
+				return nil, fmt.Errorf("%s: tmpfs mounts cannot have a source", where)
+			}
+			res = append(res, mount.Mount{
+				Type:     mount.TypeTmpfs,
+				Target:   target,
+				ReadOnly: m.ReadOnly,
+			})
+		default:
+			return nil, fmt.Errorf(
+				"%s: unknown mount type %q (expected bind, volume or tmpfs)", where, m.Type,
+			)
+		}
+	}
+
+	// telerun credentials are bind mounted separately.
+	if config.Telerun && targets[shared.CONTAINER_HOME+"/.telerun"] {
+		return nil, fmt.Errorf("%s/.telerun is already mounted for telerun", shared.CONTAINER_HOME)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	dockerClient "github.com/moby/moby/client"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Parses os/arch[/variant]. A bare architecture means linux.
+func ParsePlatform(s string) (v1.Platform, error) {
+	parts := strings.Split(s, "/")
+	for _, part := range parts {
+		if part == "" {
+			return v1.Platform{}, fmt.Errorf("invalid platform %q (expected os/arch[/variant])", s)
+		}
+	}
+	switch len(parts) {
+	case 1:
+		return v1.Platform{OS: "linux", Architecture: parts[0]}, nil
+	case 2:
+		return v1.Platform{OS: parts[0], Architecture: parts[1]}, nil
+	case 3:
+		return v1.Platform{OS: parts[0], Architecture: parts[1], Variant: parts[2]}, nil
+	}
+	return v1.Platform{}, fmt.Errorf("invalid platform %q (expected os/arch[/variant])", s)
+}
+
+func FormatPlatform(p v1.Platform) string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// containers are always linux, even on macOS hosts.
+func HostPlatform() v1.Platform {
+	return v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// platforms the image is available for, from the registry's manifest list if
+// it can be reached and otherwise from the local copy. nil if neither is
+// available.
+func (d *DevClient) imagePlatforms(image string) []v1.Platform {
+	// without credentials a private registry refuses, and we fall back to
+	// the local copy like for any other failure.
+	auth, _ := registryAuth(image)
+	dist, err := d.client.DistributionInspect(d.ctx, image, dockerClient.DistributionInspectOptions{
+		EncodedRegistryAuth: auth,
+	})
+	if err == nil && len(dist.Platforms) > 0 {
+		return dist.Platforms
+	}
+	local, err := d.client.ImageInspect(d.ctx, image)
+	if err == nil {
+		return []v1.Platform{{
+			OS:           local.Os,
+			Architecture: local.Architecture,
+			Variant:      local.Variant,
+		}}
+	}
+	return nil
+}
+
+// Picks the platform to run the configured image on. A platform set in the
+// config (or with --platform) is used as is. Otherwise we use the host's
+// platform if the image provides it (or we build it), and warn before falling back to a
+// variant that will run under emulation.
+func (d *DevClient) ResolvePlatform(config *DevConfig) (v1.Platform, error) {
+	if config.Platform != "" {
+		return ParsePlatform(config.Platform)
+	}
+
+	host := HostPlatform()
+	// locally built images are built for the host.
+	if config.Build != nil {
+		return host, nil
+	}
+	platforms := d.imagePlatforms(config.Image)
+	if len(platforms) == 0 {
+		return host, nil
+	}
+
+	var fallback *v1.Platform
+	for i, p := range platforms {
+		if p.OS != host.OS {
+			continue
+		}
+		if p.Architecture == host.Architecture {
+			return host, nil
+		}
+		// amd64 has the best emulation support, so prefer it.
+		if fallback == nil || p.Architecture == "amd64" {
+			fallback = &platforms[i]
+		}
+	}
+	if fallback == nil {
+		return host, fmt.Errorf("%s has no %s variant", config.Image, host.OS)
+	}
+
+	res := v1.Platform{
+		OS:           fallback.OS,
+		Architecture: fallback.Architecture,
+		Variant:      fallback.Variant,
+	}
+	fmt.Fprintf(
+		os.Stderr,
+		"Warning: %s has no %s variant; falling back to %s under emulation\n",
+		config.Image, FormatPlatform(host), FormatPlatform(res),
+	)
+	return res, nil
+}
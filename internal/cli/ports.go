@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/network"
+	dockerClient "github.com/moby/moby/client"
+)
+
+// A port published with the ports config key.
+type PortSpec struct {
+	// host address to listen on. Docker's default (all interfaces) if unset.
+	HostIP netip.Addr
+	// host port, or "" for one picked by docker.
+	HostPort string
+	Port     network.Port
+}
+
+// Parses a port in docker's -p format: [[host-ip:]host-port:]container-port[/proto].
+// IPv6 host addresses go in brackets.
+func ParsePortSpec(spec string) (PortSpec, error) {
+	var res PortSpec
+	rest := spec
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]:")
+		if end < 0 {
+			return res, fmt.Errorf("ports: invalid port %q", spec)
+		}
+		ip, err := netip.ParseAddr(rest[1:end])
+		if err != nil {
+			return res, fmt.Errorf("ports: %q: %w", spec, err)
+		}
+		res.HostIP = ip
+		rest = rest[end+2:]
+	}
+
+	parts := strings.Split(rest, ":")
+	switch {
+	case len(parts) == 3 && !res.HostIP.IsValid():
+		ip, err := netip.ParseAddr(parts[0])
+		if err != nil {
+			return res, fmt.Errorf("ports: %q: %w", spec, err)
+		}
+		res.HostIP = ip
+		parts = parts[1:]
+	case len(parts) > 2, len(parts) == 1 && res.HostIP.IsValid():
+		return res, fmt.Errorf("ports: invalid port %q", spec)
+	}
+	if len(parts) == 2 {
+		// an empty host port (as in 127.0.0.1::80) lets docker pick one.
+		if _, err := parsePortNumber(parts[0]); parts[0] != "" && err != nil {
+			return res, fmt.Errorf("ports: %q: %w", spec, err)
+		}
+		res.HostPort = parts[0]
+		parts = parts[1:]
+	}
+
+	number, proto, _ := strings.Cut(parts[0], "/")
+	if _, err := parsePortNumber(number); err != nil {
+		return res, fmt.Errorf("ports: %q: %w", spec, err)
+	}
+	switch proto {
+	case "", "tcp", "udp", "sctp":
+	default:
+		return res, fmt.Errorf("ports: %q: unknown protocol %q", spec, proto)
+	}
+	port, err := network.ParsePort(parts[0])
+	if err != nil {
+		return res, fmt.Errorf("ports: %q: %w", spec, err)
+	}
+	res.Port = port
+	return res, nil
+}
+
+func parsePortNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("invalid port number %q", s)
+	}
+	return n, nil
+}
+
+// Returns the ports to expose and publish for the ports config key.
+func (c *DevConfig) PortBindings() (network.PortSet, network.PortMap, error) {
+	if len(c.Ports) == 0 {
+		return nil, nil, nil
+	}
+	exposed := network.PortSet{}
+	bindings := network.PortMap{}
+	for _, spec := range c.Ports {
+		port, err := ParsePortSpec(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		exposed[port.Port] = struct{}{}
+		bindings[port.Port] = append(bindings[port.Port], network.PortBinding{
+			HostIP:   port.HostIP,
+			HostPort: port.HostPort,
+		})
+	}
+	return exposed, bindings, nil
+}
+
+// Returns the ports a container publishes on the host.
+func (d *DevClient) PublishedPorts(containerName string) (network.PortMap, error) {
+	result, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if result.Container.NetworkSettings == nil {
+		return nil, nil
+	}
+	return result.Container.NetworkSettings.Ports, nil
+}
+
+// Copies between stdin/stdout and 127.0.0.1:$1 in the container, with socat
+// if the image has it and bash's /dev/tcp otherwise. /dev/tcp can't
+// half-close a connection, so the relay ends as soon as either side is done.
+const portRelay = `if command -v socat >/dev/null 2>&1; then
+	exec socat - "TCP:127.0.0.1:$1"
+fi
+exec bash -c 'exec 3<>"/dev/tcp/127.0.0.1/$1" || exit 1
+cat <&3 &
+reader=$!
+cat <&0 >&3 &
+writer=$!
+wait -n
+kill "$reader" "$writer" 2>/dev/null
+exit 0' relay "$1"`
+
+// Relays every connection accepted by listener to port inside the container,
+// until the listener is closed. Each connection gets its own exec, so the
+// container doesn't need to publish the port.
+func (d *DevClient) ForwardPort(containerName string, listener net.Listener, port int) error {
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	userSpec := fmt.Sprintf("%s:%s", u.Uid, u.Gid)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := d.relay(containerName, userSpec, port, conn); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: connection from %s: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (d *DevClient) relay(containerName string, userSpec string, port int, conn net.Conn) error {
+	execResp, err := d.client.ExecCreate(d.ctx, containerName, dockerClient.ExecCreateOptions{
+		User:         userSpec,
+		Cmd:          []string{"/bin/sh", "-c", portRelay, "sh", strconv.Itoa(port)},
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	attachResp, err := d.client.ExecAttach(d.ctx, execResp.ID, dockerClient.ExecAttachOptions{})
+	if err != nil {
+		return err
+	}
+	defer attachResp.Close()
+
+	go func() {
+		_, _ = io.Copy(attachResp.Conn, conn)
+		_ = attachResp.CloseWrite()
+	}()
+
+	var stderr strings.Builder
+	if _, err := stdcopy.StdCopy(conn, &stderr, attachResp.Reader); err != nil {
+		return err
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
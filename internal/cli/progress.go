@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/term"
+)
+
+// Shows the messages of an image pull.
+type pullRenderer interface {
+	message(msg *pullMessage)
+	// called once at the end of the pull, whether it succeeded or not.
+	finish()
+}
+
+// renders progress bars in place on a terminal, and falls back to one line
+// per message otherwise. Progress goes to stderr, so that stdout only carries
+// what commands output.
+func newPullRenderer() pullRenderer {
+	fd := int(os.Stderr.Fd())
+	if !term.IsTerminal(fd) {
+		return &lineRenderer{}
+	}
+	width, _, err := term.GetSize(fd)
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	return &ttyRenderer{
+		width:  width,
+		layers: make(map[string]*layerProgress),
+		start:  time.Now(),
+	}
+}
+
+// Matches docker CLI's non-TTY behavior.
+type lineRenderer struct{}
+
+func (r *lineRenderer) message(msg *pullMessage) {
+	switch {
+	case msg.ID != "" && msg.Progress != "":
+		fmt.Fprintf(os.Stderr, "%s: %s %s\n", msg.ID, msg.Status, msg.Progress)
+	case msg.ID != "":
+		fmt.Fprintf(os.Stderr, "%s: %s\n", msg.ID, msg.Status)
+	case msg.Status != "":
+		fmt.Fprintln(os.Stderr, msg.Status)
+	}
+}
+
+func (r *lineRenderer) finish() {}
+
+// Prints nothing, keeping the digest of the pulled image.
+type quietRenderer struct {
+	digest string
+}
+
+func (r *quietRenderer) message(msg *pullMessage) {
+	if digest, ok := strings.CutPrefix(msg.Status, "Digest: "); ok {
+		r.digest = digest
+	}
+}
+
+func (r *quietRenderer) finish() {}
+
+type layerProgress struct {
+	status  string
+	current int64
+	total   int64
+	// bytes of the layer downloaded so far, and its download size. Kept apart
+	// from current/total since those also track extraction.
+	downloaded   int64
+	downloadSize int64
+}
+
+// Redraws one line per layer in place, followed by a summary line, like
+// docker pull does on a terminal.
+type ttyRenderer struct {
+	width  int
+	order  []string
+	layers map[string]*layerProgress
+	// number of lines drawn by the last redraw, to move back over.
+	drawn    int
+	start    time.Time
+	lastDraw time.Time
+}
+
+func (r *ttyRenderer) message(msg *pullMessage) {
+	if msg.ID == "" {
+		// messages about the whole pull go above the progress bars.
+		if msg.Status != "" {
+			r.clear()
+			fmt.Fprintln(os.Stderr, msg.Status)
+			r.draw()
+		}
+		return
+	}
+
+	layer, ok := r.layers[msg.ID]
+	if !ok {
+		layer = &layerProgress{}
+		r.layers[msg.ID] = layer
+		r.order = append(r.order, msg.ID)
+	}
+	layer.status = msg.Status
+	layer.current = msg.ProgressDetail.Current
+	layer.total = msg.ProgressDetail.Total
+
+	switch msg.Status {
+	case "Downloading":
+		layer.downloaded = layer.current
+		layer.downloadSize = layer.total
+	case "Download complete", "Extracting", "Pull complete":
+		layer.downloaded = layer.downloadSize
+	}
+
+	// redrawing on every message makes the terminal flicker.
+	if !ok || time.Since(r.lastDraw) > 100*time.Millisecond {
+		r.redraw()
+	}
+}
+
+func (r *ttyRenderer) finish() {
+	r.redraw()
+}
+
+// moves the cursor back to the start of the last draw and clears it.
+func (r *ttyRenderer) clear() {
+	if r.drawn > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA\033[J", r.drawn)
+	}
+	r.drawn = 0
+}
+
+func (r *ttyRenderer) redraw() {
+	r.clear()
+	r.draw()
+}
+
+func (r *ttyRenderer) draw() {
+	r.lastDraw = time.Now()
+	if len(r.order) == 0 {
+		return
+	}
+
+	var downloaded, total int64
+	for _, id := range r.order {
+		layer := r.layers[id]
+		downloaded += layer.downloaded
+		total += layer.downloadSize
+
+		line := fmt.Sprintf("%s: %s", id, layer.status)
+		if layer.total > 0 {
+			line += fmt.Sprintf(
+				" %s %s/%s",
+				progressBar(layer.current, layer.total, 30),
+				units.HumanSize(float64(layer.current)),
+				units.HumanSize(float64(layer.total)),
+			)
+		}
+		r.println(line)
+	}
+
+	summary := fmt.Sprintf(
+		"Total: %s/%s", units.HumanSize(float64(downloaded)), units.HumanSize(float64(total)),
+	)
+	if elapsed := time.Since(r.start).Seconds(); elapsed > 0 && downloaded > 0 {
+		rate := float64(downloaded) / elapsed
+		summary += fmt.Sprintf("  %s/s", units.HumanSize(rate))
+		if remaining := total - downloaded; remaining > 0 {
+			eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+			summary += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+		}
+	}
+	r.println(summary)
+}
+
+// prints a single line, cut to the terminal width so it never wraps (which
+// would throw off how far clear has to move back).
+func (r *ttyRenderer) println(line string) {
+	if len(line) >= r.width {
+		line = line[:r.width-1]
+	}
+	fmt.Fprintln(os.Stderr, line)
+	r.drawn++
+}
+
+func progressBar(current, total int64, width int) string {
+	filled := int(float64(width) * float64(current) / float64(total))
+	filled = min(max(filled, 0), width)
+	if filled == width {
+		return "[" + strings.Repeat("=", width) + "]"
+	}
+	return "[" + strings.Repeat("=", filled) + ">" + strings.Repeat(" ", width-filled-1) + "]"
+}
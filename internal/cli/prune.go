@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	dockerClient "github.com/moby/moby/client"
+)
+
+type PrunableContainer struct {
+	*ContainerInfo
+	Reason string
+}
+
+type PrunableImage struct {
+	ID         string
+	Repository string
+	Size       int64
+	// tags to remove, for superseded builds that are still tagged. The
+	// image goes away with its last tag.
+	Tags []string
+}
+
+// What `dev106 prune` would remove.
+type PruneCandidates struct {
+	Containers []*PrunableContainer
+	Volumes    []string
+	Images     []*PrunableImage
+}
+
+func (p *PruneCandidates) Empty() bool {
+	return len(p.Containers) == 0 && len(p.Volumes) == 0 && len(p.Images) == 0
+}
+
+// repository name of an image reference or repo digest, or "" if it doesn't
+// parse.
+func repositoryName(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	return named.Name()
+}
+
+// Finds what can be garbage collected:
+//
+//   - containers whose repository root no longer exists, and stopped
+//     containers that haven't run for longer than idle (if idle is nonzero)
+//   - volumes dev106 created that no remaining container uses
+//   - untagged images from the repositories dev106 images come from (those
+//     of image and of every container) that no remaining container uses
+//   - images built from a [build] section, other than image, that no
+//     remaining container uses; each build gets a new tag, so the old ones
+//     are never untagged. These are kept if image is "", as when the config
+//     couldn't be loaded
+//
+// Containers created before dev106 recorded their repository are left alone,
+// since there is no way to tell where they came from.
+func (d *DevClient) FindPrunable(image string, idle time.Duration) (*PruneCandidates, error) {
+	res := &PruneCandidates{}
+
+	containers, err := d.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := make(map[string]bool)
+	repositories := map[string]bool{
+		repositoryName(image): true,
+	}
+	for _, c := range containers {
+		repositories[repositoryName(c.Image)] = true
+		if c.Repo == "" {
+			continue
+		}
+
+		reason := ""
+		if _, err := os.Stat(c.Repo); errors.Is(err, fs.ErrNotExist) {
+			reason = "repository no longer exists"
+		} else if idle > 0 && c.State != "running" {
+			last := c.FinishedAt
+			if last.IsZero() {
+				last = c.Created
+			}
+			if since := time.Since(last); since > idle {
+				reason = fmt.Sprintf("stopped for %s", since.Round(time.Hour))
+			}
+		}
+		if reason != "" {
+			res.Containers = append(res.Containers, &PrunableContainer{c, reason})
+			pruned[c.Name] = true
+		}
+	}
+	delete(repositories, "")
+
+	// volumes and images are only in use if some container we're keeping
+	// (dev106 or not) uses them.
+	all, err := d.client.ContainerList(d.ctx, dockerClient.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	usedVolumes := make(map[string]bool)
+	usedImages := make(map[string]bool)
+	for _, summary := range all.Items {
+		if len(summary.Names) > 0 && pruned[strings.TrimPrefix(summary.Names[0], "/")] {
+			continue
+		}
+		usedImages[summary.ImageID] = true
+		for _, m := range summary.Mounts {
+			if m.Name != "" {
+				usedVolumes[m.Name] = true
+			}
+		}
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := d.client.VolumeList(d.ctx, dockerClient.VolumeListOptions{
+		Filters: make(dockerClient.Filters).Add("label", LabelUser+"="+u.Username),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range volumes.Items {
+		if !usedVolumes[v.Name] {
+			res.Volumes = append(res.Volumes, v.Name)
+		}
+	}
+
+	images, err := d.client.ImageList(d.ctx, dockerClient.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range images.Items {
+		if usedImages[summary.ID] {
+			continue
+		}
+		var tags, built []string
+		for _, tag := range summary.RepoTags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+			tags = append(tags, tag)
+			// without the current image, a superseded build can't be told
+			// from the one in use.
+			if image != "" && tag != image && strings.HasPrefix(tag, buildRepository+":") {
+				built = append(built, tag)
+			}
+		}
+		if len(tags) > 0 {
+			if len(built) == len(tags) {
+				res.Images = append(res.Images, &PrunableImage{
+					ID:         summary.ID,
+					Repository: buildRepository,
+					Size:       summary.Size,
+					Tags:       built,
+				})
+			}
+			continue
+		}
+		for _, digest := range summary.RepoDigests {
+			if repo := repositoryName(digest); repositories[repo] {
+				res.Images = append(res.Images, &PrunableImage{
+					ID:         summary.ID,
+					Repository: repo,
+					Size:       summary.Size,
+				})
+				break
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// Removes everything in candidates, containers first so that the volumes and
+// images they used are free to go. Keeps going past failures and reports them
+// all at the end.
+func (d *DevClient) Prune(candidates *PruneCandidates) error {
+	var errs []error
+	for _, c := range candidates.Containers {
+		if err := d.Delete(c.Name); err != nil {
+			errs = append(errs, fmt.Errorf("container %s: %w", c.Name, err))
+			continue
+		}
+		_ = RemoveSession(c.Name)
+	}
+	for _, name := range candidates.Volumes {
+		if _, err := d.client.VolumeRemove(d.ctx, name, dockerClient.VolumeRemoveOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("volume %s: %w", name, err))
+		}
+	}
+	for _, image := range candidates.Images {
+		refs := image.Tags
+		if len(refs) == 0 {
+			refs = []string{image.ID}
+		}
+		for _, ref := range refs {
+			_, err := d.client.ImageRemove(d.ctx, ref, dockerClient.ImageRemoveOptions{
+				PruneChildren: true,
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("image %s: %w", ref, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
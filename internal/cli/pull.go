@@ -2,28 +2,149 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"github.com/containerd/errdefs"
 	dockerClient "github.com/moby/moby/client"
 	"github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type pullMessage struct {
-	Status   string `json:"status,omitempty"`
-	ID       string `json:"id,omitempty"`
-	Progress string `json:"progress,omitempty"`
-	Error    string `json:"error,omitempty"`
+	Status         string `json:"status,omitempty"`
+	ID             string `json:"id,omitempty"`
+	Progress       string `json:"progress,omitempty"`
+	ProgressDetail struct {
+		Current int64 `json:"current,omitempty"`
+		Total   int64 `json:"total,omitempty"`
+	} `json:"progressDetail"`
+	Error string `json:"error,omitempty"`
 }
 
-func (d *DevClient) Pull(config *DevConfig) error {
+const (
+	PullMissing = "missing"
+	PullAlways  = "always"
+	PullNever   = "never"
+)
+
+// Pulls the configured image. With quiet set, only the digest of the pulled
+// image is printed.
+func (d *DevClient) Pull(config *DevConfig, quiet bool) error {
+	if config.Build != nil {
+		return errors.New("the image is built from the [build] section; run `dev106 build` instead")
+	}
+	platform, err := d.ResolvePlatform(config)
+	if err != nil {
+		return err
+	}
+	if !quiet {
+		return d.pull(config.Image, platform, newPullRenderer())
+	}
+
+	out := &quietRenderer{}
+	if err := d.pull(config.Image, platform, out); err != nil {
+		return err
+	}
+	digest := out.digest
+	if digest == "" {
+		// not every daemon reports the digest while pulling.
+		image, err := d.client.ImageInspect(d.ctx, config.Image)
+		if err != nil {
+			return err
+		}
+		if len(image.RepoDigests) == 0 {
+			return fmt.Errorf("no digest known for image %s", config.Image)
+		}
+		digest = image.RepoDigests[0]
+		if _, after, ok := strings.Cut(digest, "@"); ok {
+			digest = after
+		}
+	}
+	fmt.Println(digest)
+	return nil
+}
+
+// Makes sure the configured image is available for platform before a
+// container is created from it, pulling it according to pull_policy or
+// building it from the [build] section.
+func (d *DevClient) ensureImage(config *DevConfig, platform v1.Platform) error {
+	if config.Build != nil {
+		return d.EnsureBuilt(config)
+	}
+	switch config.PullPolicy {
+	case PullAlways:
+		return d.pull(config.Image, platform, newPullRenderer())
+	case PullNever:
+		local, err := d.client.ImageInspect(d.ctx, config.Image)
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf(
+				"image %s is not available locally and pull_policy is %q; run `dev106 pull`",
+				config.Image, PullNever,
+			)
+		}
+		if err != nil {
+			return err
+		}
+		if !localMatches(local, platform) {
+			return fmt.Errorf(
+				"image %s is available locally for %s but not %s, and pull_policy is %q; run `dev106 pull`",
+				config.Image, localPlatform(local), FormatPlatform(platform), PullNever,
+			)
+		}
+		return nil
+	default:
+		local, err := d.client.ImageInspect(d.ctx, config.Image)
+		if errdefs.IsNotFound(err) {
+			fmt.Fprintf(os.Stderr, "Image %s not found locally, pulling it\n", config.Image)
+			return d.pull(config.Image, platform, newPullRenderer())
+		}
+		if err != nil {
+			return err
+		}
+		if !localMatches(local, platform) {
+			fmt.Fprintf(
+				os.Stderr,
+				"Image %s is only available locally for %s, pulling it for %s\n",
+				config.Image, localPlatform(local), FormatPlatform(platform),
+			)
+			return d.pull(config.Image, platform, newPullRenderer())
+		}
+		return nil
+	}
+}
+
+// the platform of a local image, as os/arch[/variant].
+func localPlatform(image dockerClient.ImageInspectResult) string {
+	return FormatPlatform(v1.Platform{
+		OS:           image.Os,
+		Architecture: image.Architecture,
+		Variant:      image.Variant,
+	})
+}
+
+// Whether the local copy of an image is for platform. Variants are only
+// compared when both sides name one, since images often leave it out.
+func localMatches(image dockerClient.ImageInspectResult, platform v1.Platform) bool {
+	if image.Os != platform.OS || image.Architecture != platform.Architecture {
+		return false
+	}
+	return image.Variant == "" || platform.Variant == "" || image.Variant == platform.Variant
+}
+
+func (d *DevClient) pull(image string, platform v1.Platform, out pullRenderer) error {
+	auth, err := registryAuth(image)
+	if err != nil {
+		return err
+	}
 	resp, err := d.client.ImagePull(
 		d.ctx,
-		config.Image,
+		image,
 		dockerClient.ImagePullOptions{
-			Platforms: []v1.Platform{
-				{OS: "linux", Architecture: "amd64"},
-			},
+			Platforms:    []v1.Platform{platform},
+			RegistryAuth: auth,
 		},
 	)
 	if err != nil {
@@ -39,23 +160,18 @@ func (d *DevClient) Pull(config *DevConfig) error {
 			if err == io.EOF {
 				break
 			}
+			out.finish()
 			return err
 		}
 
 		if msg.Error != "" {
+			out.finish()
 			return fmt.Errorf("docker pull failed: %s", msg.Error)
 		}
 
-		// Match docker CLI's non-TTY behavior
-		switch {
-		case msg.ID != "" && msg.Progress != "":
-			fmt.Printf("%s: %s %s\n", msg.ID, msg.Status, msg.Progress)
-		case msg.ID != "":
-			fmt.Printf("%s: %s\n", msg.ID, msg.Status)
-		case msg.Status != "":
-			fmt.Println(msg.Status)
-		}
+		out.message(&msg)
 	}
 
+	out.finish()
 	return nil
 }
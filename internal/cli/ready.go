@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/junikimm717/dev106/internal/shared"
+	dockerClient "github.com/moby/moby/client"
+	"golang.org/x/term"
+)
+
+// how long to wait for the bootstrapper's first status before assuming the
+// image's bootstrapper predates status reporting.
+const legacyBootstrapGrace = 5 * time.Second
+
+const defaultReadyTimeout = 2 * time.Minute
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// how long to wait for the container to become ready.
+func (c *DevConfig) ReadyTimeoutDuration() (time.Duration, error) {
+	if c.ReadyTimeout == "" {
+		return defaultReadyTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.ReadyTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("ready_timeout: %w", err)
+	}
+	return timeout, nil
+}
+
+// reads the bootstrapper's status file, or returns nil if it hasn't written
+// one yet.
+func (d *DevClient) readStatus(containerName string) (*shared.BootstrapStatus, error) {
+	result, err := d.client.CopyFromContainer(d.ctx, containerName, dockerClient.CopyFromContainerOptions{
+		SourcePath: shared.STATUS_FILE,
+	})
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer result.Content.Close()
+
+	archive := tar.NewReader(result.Content)
+	if _, err := archive.Next(); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var status shared.BootstrapStatus
+	if err := json.NewDecoder(archive).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Waits for the container's bootstrapper to report that it is ready, showing
+// a spinner on a terminal. Fails if the bootstrapper reports an error, the
+// container exits, or timeout passes first.
+func (d *DevClient) WaitReady(containerName string, timeout time.Duration) error {
+	inspect, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
+	if err != nil {
+		return err
+	}
+	started, err := time.Parse(time.RFC3339Nano, inspect.Container.State.StartedAt)
+	if err != nil {
+		started = time.Now()
+	}
+
+	tty := term.IsTerminal(int(os.Stderr.Fd()))
+	clear := func() {
+		if tty {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+	}
+	defer clear()
+
+	deadline := time.Now().Add(timeout)
+	phase := shared.PhaseStarting
+	for frame := 0; ; frame++ {
+		status, err := d.readStatus(containerName)
+		if err != nil {
+			return err
+		}
+		// a status from before this start is left over from the last time the
+		// container ran.
+		if status != nil && status.Time.Before(started.Add(-time.Second)) {
+			status = nil
+		}
+
+		if status != nil {
+			phase = status.Phase
+			if status.Error != "" {
+				clear()
+				return fmt.Errorf(
+					"container bootstrap failed during %s: %s\nRun `dev106 logs` for details.",
+					status.Phase, status.Error,
+				)
+			}
+			if status.Phase == shared.PhaseReady {
+				clear()
+				for _, warning := range status.Warnings {
+					fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+				}
+				return nil
+			}
+		} else if time.Since(started) > legacyBootstrapGrace {
+			clear()
+			fmt.Fprintln(os.Stderr, "Warning: the image's bootstrapper does not report readiness; not waiting for it.")
+			return nil
+		}
+
+		state, err := d.ContainerState(containerName)
+		if err != nil {
+			return err
+		}
+		if state != "running" {
+			clear()
+			return fmt.Errorf("container %s %s while bootstrapping", containerName, state)
+		}
+
+		if time.Now().After(deadline) {
+			clear()
+			return fmt.Errorf(
+				"container %s was not ready after %s (stuck in %s)", containerName, timeout, phase,
+			)
+		}
+
+		if tty {
+			fmt.Fprintf(
+				os.Stderr, "\r\033[K%s Waiting for container to be ready (%s)",
+				spinnerFrames[frame%len(spinnerFrames)], phase,
+			)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/moby/moby/api/types/container"
+)
+
+// resource limits in the config. Sizes are human readable ("4g", "512m").
+type ResourceConfig struct {
+	CPUs       float64 `toml:"cpus,omitzero"`
+	Cpuset     string  `toml:"cpuset,omitempty"`
+	Memory     string  `toml:"memory,omitempty"`
+	MemorySwap string  `toml:"memory_swap,omitempty"`
+	PidsLimit  int64   `toml:"pids_limit,omitzero"`
+	ShmSize    string  `toml:"shm_size,omitempty"`
+}
+
+func parseSize(key string, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	// memory_swap uses -1 for unlimited, like docker run.
+	if value == "-1" {
+		return -1, nil
+	}
+	n, err := units.RAMInBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+// number of CPUs in a cpuset list such as "0-3,6".
+func cpusetSize(cpuset string) (int, error) {
+	count := 0
+	for part := range strings.SplitSeq(cpuset, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return 0, fmt.Errorf("cpuset: invalid cpu %q", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(hi)
+			if err != nil || end < start {
+				return 0, fmt.Errorf("cpuset: invalid range %q", part)
+			}
+		}
+		count += end - start + 1
+	}
+	return count, nil
+}
+
+// Converts the configured limits to what the daemon expects. The shm size is
+// returned separately since it lives on the HostConfig, not in Resources.
+func (r *ResourceConfig) Resources() (container.Resources, int64, error) {
+	res := container.Resources{}
+
+	if r.CPUs < 0 {
+		return res, 0, fmt.Errorf("cpus: must be positive")
+	}
+	res.NanoCPUs = int64(r.CPUs * 1e9)
+
+	if r.Cpuset != "" {
+		if _, err := cpusetSize(r.Cpuset); err != nil {
+			return res, 0, err
+		}
+		res.CpusetCpus = r.Cpuset
+	}
+
+	memory, err := parseSize("memory", r.Memory)
+	if err != nil {
+		return res, 0, err
+	}
+	res.Memory = memory
+
+	swap, err := parseSize("memory_swap", r.MemorySwap)
+	if err != nil {
+		return res, 0, err
+	}
+	if swap > 0 && swap < memory {
+		return res, 0, fmt.Errorf("memory_swap: must be at least memory (%s)", r.Memory)
+	}
+	res.MemorySwap = swap
+
+	if r.PidsLimit != 0 {
+		pids := r.PidsLimit
+		res.PidsLimit = &pids
+	}
+
+	shm, err := parseSize("shm_size", r.ShmSize)
+	if err != nil {
+		return res, 0, err
+	}
+
+	return res, shm, nil
+}
+
+// Number of CPUs the container may actually use, or 0 if it is unlimited.
+// This is what DEV_NPROC is set to, since nproc inside the container only
+// sees the cpuset and not the CPU quota.
+func (r *ResourceConfig) NProc() int {
+	n := 0
+	if r.CPUs > 0 {
+		n = max(1, int(math.Ceil(r.CPUs)))
+	}
+	if r.Cpuset != "" {
+		if size, err := cpusetSize(r.Cpuset); err == nil && (n == 0 || size < n) {
+			n = size
+		}
+	}
+	return n
+}
@@ -0,0 +1,447 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/junikimm717/dev106/internal/shared"
+	dockerClient "github.com/moby/moby/client"
+)
+
+// socket Docker Desktop forwards the macOS ssh agent to. Unix sockets can't
+// be shared through its bind mounts, so there is nothing to relay to there.
+const dockerDesktopSSHAgent = "/run/host-services/ssh-auth.sock"
+
+// Hidden command that runs the session relay, as in
+// `dev106 session-relay <session dir> <repository root>`.
+const RelayCommand = "session-relay"
+
+// files in the session directory.
+const (
+	// sockets the relay serves for the ssh agent and git credentials.
+	agentSocket      = "agent"
+	credentialSocket = "git-credential"
+	// path of the host ssh agent the relay forwards to, as of the most
+	// recent session.
+	agentTarget = "ssh-auth-sock"
+	// held by the running relay.
+	relayLock = "relay.lock"
+	relayLog  = "relay.log"
+	// the flags the running relay was started with.
+	relayFlags = "relay.flags"
+)
+
+// What the session relay serves. Each socket only exists while the most
+// recent session asks for it.
+type RelayFeatures struct {
+	SSHAgent       bool
+	GitCredentials bool
+}
+
+// flags of the session relay command for f.
+func (f RelayFeatures) flags() []string {
+	var flags []string
+	if f.SSHAgent {
+		flags = append(flags, "--ssh-agent")
+	}
+	if f.GitCredentials {
+		flags = append(flags, "--git-credentials")
+	}
+	return flags
+}
+
+// the sockets the relay listens on for f.
+func (f RelayFeatures) sockets() []string {
+	var sockets []string
+	if f.SSHAgent {
+		sockets = append(sockets, agentSocket)
+	}
+	if f.GitCredentials {
+		sockets = append(sockets, credentialSocket)
+	}
+	return sockets
+}
+
+// how often the relay checks that its session directory is still there.
+const relayPoll = 10 * time.Second
+
+// how long a session waits for a newly started relay to listen.
+const relayStartTimeout = 2 * time.Second
+
+// Host directory shared with containerName at shared.SESSION_DIR. It lives in
+// the state directory rather than $XDG_RUNTIME_DIR: the container keeps the
+// directory it was created with, so it must survive logging out.
+func SessionDir(containerName string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions", containerName), nil
+}
+
+// Removes the session directory of a deleted container, which also stops its
+// relay.
+func RemoveSession(containerName string) error {
+	dir, err := SessionDir(containerName)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// whether the config needs the session directory mounted.
+func (c *DevConfig) needsSession() bool {
+	return c.SSHAgent || c.GitIdentity || c.GitCredentials
+}
+
+// Bind mounts for sharing session state (such as the ssh agent and git
+// config) with containerName.
+func SessionMounts(config *DevConfig, containerName string) ([]string, error) {
+	if !config.needsSession() {
+		return nil, nil
+	}
+	dir, err := SessionDir(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	binds := []string{fmt.Sprintf("%s:%s:rw", dir, shared.SESSION_DIR)}
+	if config.SSHAgent && runtime.GOOS == "darwin" {
+		// the bootstrapper hands it to the dev user.
+		binds = append(binds, fmt.Sprintf("%s:%s", dockerDesktopSSHAgent, shared.SSH_AGENT_SOCK))
+	}
+	return binds, nil
+}
+
+// Sets up what config asks for in a new session in containerName, the
+// container for the repository at root, and returns the environment pointing
+// the session at it. The sockets are served by a relay that runs in the
+// background for as long as the container exists, so they keep working in
+// tmux sessions and detached shells after the CLI exits.
+func (d *DevClient) StartSession(config *DevConfig, containerName string, root string) ([]string, error) {
+	if !config.needsSession() {
+		return nil, nil
+	}
+	dir, err := SessionDir(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	var env []string
+	var features RelayFeatures
+	if config.SSHAgent {
+		agentEnv, err := forwardSSHAgent(dir)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, agentEnv...)
+		features.SSHAgent = runtime.GOOS != "darwin"
+	}
+	if config.GitIdentity || config.GitCredentials {
+		// the credential helper is the image's bootstrapper.
+		result, err := d.client.ContainerInspect(d.ctx, containerName, dockerClient.ContainerInspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		var entrypoint []string
+		if result.Container.Config != nil {
+			entrypoint = result.Container.Config.Entrypoint
+		}
+		gitEnv, credentials, err := forwardGit(dir, root, config, entrypoint)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, gitEnv...)
+		features.GitCredentials = credentials
+	}
+	if err := startRelay(dir, root, features); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Points the relay at the host's ssh agent as of this session, so sessions
+// keep working after the agent moves (e.g. on a new login).
+func forwardSSHAgent(dir string) ([]string, error) {
+	if runtime.GOOS == "darwin" {
+		return []string{"SSH_AUTH_SOCK=" + shared.SSH_AGENT_SOCK}, nil
+	}
+	agent := os.Getenv("SSH_AUTH_SOCK")
+	if agent == "" {
+		fmt.Fprintln(os.Stderr, "Warning: ssh_agent is set, but there is no ssh agent (SSH_AUTH_SOCK is empty)")
+		return nil, nil
+	}
+	if err := writeSessionFile(dir, agentTarget, []byte(agent+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("ssh_agent: %w", err)
+	}
+	return []string{"SSH_AUTH_SOCK=" + path.Join(shared.SESSION_DIR, agentSocket)}, nil
+}
+
+// replaces the file name in the session directory, so that a reader never
+// sees it half written.
+func writeSessionFile(dir string, name string, data []byte, perm os.FileMode) error {
+	file := filepath.Join(dir, name)
+	tmp := file + fmt.Sprintf(".%d.tmp", os.Getpid())
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// whether a relay holds the lock in dir.
+func relayRunning(dir string) (bool, error) {
+	lock, err := os.OpenFile(filepath.Join(dir, relayLock), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Close()
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Starts the relay for the session directory dir in the background, serving
+// features, and waits for it to listen. A relay that is already running is
+// kept if it serves the same features and replaced otherwise, so that a
+// session that stops asking for a feature also stops it for older sessions.
+func startRelay(dir string, root string, features RelayFeatures) error {
+	flags := features.flags()
+	running, err := relayRunning(dir)
+	if err != nil {
+		return err
+	}
+	if running {
+		current, err := os.ReadFile(filepath.Join(dir, relayFlags))
+		if err == nil && string(current) == strings.Join(flags, " ")+"\n" {
+			return nil
+		}
+		stopRelay(dir)
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, relayLog), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	// removed so we can tell when the relay has replaced them.
+	for _, name := range []string{agentSocket, credentialSocket} {
+		_ = os.Remove(filepath.Join(dir, name))
+	}
+	if err := writeSessionFile(dir, relayFlags, []byte(strings.Join(flags, " ")+"\n"), 0o600); err != nil {
+		return err
+	}
+
+	args := append([]string{RelayCommand}, flags...)
+	cmd := exec.Command(exe, append(args, dir, root)...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	// its own session, so it outlives the terminal.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting the session relay: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.Now().Add(relayStartTimeout)
+	for {
+		if sessionSocketsExist(dir, features) {
+			return nil
+		}
+		select {
+		case <-exited:
+			// another CLI may have started one at the same time.
+			if sessionSocketsExist(dir, features) {
+				return nil
+			}
+			return fmt.Errorf("the session relay exited; see %s", filepath.Join(dir, relayLog))
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the session relay didn't start; see %s", filepath.Join(dir, relayLog))
+		}
+	}
+}
+
+// Stops the relay serving dir: removing its sockets cuts it off right away,
+// and it exits once it notices its lock is gone.
+func stopRelay(dir string) {
+	for _, name := range []string{relayLock, relayFlags, agentSocket, credentialSocket} {
+		_ = os.Remove(filepath.Join(dir, name))
+	}
+}
+
+func sessionSocketsExist(dir string, features RelayFeatures) bool {
+	for _, name := range features.sockets() {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs the relay for the session directory dir of the repository at root: it
+// forwards the agent socket to the host's current ssh agent and answers git
+// credential requests, as features asks, until dir is removed with its
+// container or a newer relay replaces it. Returns right away if another relay
+// is already serving dir.
+func ServeSession(dir string, root string, features RelayFeatures) error {
+	lockPath := filepath.Join(dir, relayLock)
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	// a session checking whether a relay is running holds the lock for a
+	// moment, so try a few times before deciding another relay has it.
+	for attempt := 0; ; attempt++ {
+		err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return err
+		}
+		if attempt == 10 {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	lockInfo, err := lock.Stat()
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, 2)
+	if features.SSHAgent {
+		agent, err := listenSession(dir, agentSocket)
+		if err != nil {
+			return fmt.Errorf("ssh_agent: %w", err)
+		}
+		defer agent.Close()
+		go func() {
+			errs <- serveSocket(agent, func(conn net.Conn) {
+				relayUnix(conn, readAgentTarget(dir))
+			})
+		}()
+	}
+	if features.GitCredentials {
+		credential, err := listenSession(dir, credentialSocket)
+		if err != nil {
+			return fmt.Errorf("git_credentials: %w", err)
+		}
+		defer credential.Close()
+		go func() {
+			errs <- serveSocket(credential, func(conn net.Conn) {
+				answerCredentialRequest(conn, root)
+			})
+		}()
+	}
+
+	ticker := time.NewTicker(relayPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+		}
+		// gone (or replaced) along with the container's session directory.
+		info, err := os.Stat(lockPath)
+		if err != nil || !os.SameFile(info, lockInfo) {
+			return nil
+		}
+	}
+}
+
+// listens on the socket name in dir, replacing whatever a previous relay
+// left behind.
+func listenSession(dir string, name string) (*net.UnixListener, error) {
+	socket := filepath.Join(dir, name)
+	_ = os.Remove(socket)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// a newer relay may own the path by the time this one exits.
+	listener.SetUnlinkOnClose(false)
+	// the dev user in the container has our uid, so owner-only is enough.
+	if err := os.Chmod(socket, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// calls handle for each connection to listener, until accepting fails.
+func serveSocket(listener net.Listener, handle func(net.Conn)) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+// the host ssh agent of the most recent session, or "" if there is none.
+func readAgentTarget(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, agentTarget))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// copies between conn and a new connection to the unix socket at target.
+func relayUnix(conn net.Conn, target string) {
+	defer conn.Close()
+	if target == "" {
+		return
+	}
+	upstream, err := net.Dial("unix", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		if c, ok := upstream.(*net.UnixConn); ok {
+			_ = c.CloseWrite()
+		}
+		close(done)
+	}()
+	_, _ = io.Copy(conn, upstream)
+	if c, ok := conn.(*net.UnixConn); ok {
+		_ = c.CloseWrite()
+	}
+	<-done
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"syscall"
+
+	dockerClient "github.com/moby/moby/client"
+)
+
+// Runs "$@" in its own process group and records its pid in the file named
+// by $1, so that signals can be forwarded to everything it starts. The command
+// runs in the foreground of an inner shell that execs it: sh starts
+// background jobs with SIGINT and SIGQUIT ignored, and without a terminal it
+// can't turn on job control to avoid that. The inner shell isn't a process
+// group leader, so setsid execs the command in place rather than forking.
+const execWrapper = `pidfile=$1; shift
+/bin/sh -c 'echo "$$" > "$0"
+if command -v setsid >/dev/null 2>&1; then
+	exec setsid "$@"
+fi
+exec "$@"' "$pidfile" "$@"
+status=$?
+rm -f "$pidfile"
+exit "$status"`
+
+// Sends the signal in $2 to the process group recorded in the file named by
+// $1, falling back to just the process if it didn't get its own group.
+const execKiller = `pid=$(cat "$1" 2>/dev/null) || exit 0
+kill -s "$2" -- "-$pid" 2>/dev/null || kill -s "$2" "$pid" 2>/dev/null
+exit 0`
+
+// signals forwarded to commands run by ExecCmd.
+var forwardedSignals = map[syscall.Signal]string{
+	syscall.SIGINT:  "INT",
+	syscall.SIGTERM: "TERM",
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGKILL: "KILL",
+}
+
+// wraps cmd so that signalExec can reach it, returning the wrapped command and
+// the pid file that identifies it.
+func wrapExecCmd(cmd []string) ([]string, string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	pidfile := fmt.Sprintf("/tmp/dev106-exec-%s.pid", hex.EncodeToString(id))
+	return append([]string{"/bin/sh", "-c", execWrapper, "sh", pidfile}, cmd...), pidfile, nil
+}
+
+// sends sig to the process group of a command started with wrapExecCmd.
+func (d *DevClient) signalExec(containerName string, userSpec string, pidfile string, sig syscall.Signal) error {
+	execResp, err := d.client.ExecCreate(d.ctx, containerName, dockerClient.ExecCreateOptions{
+		User: userSpec,
+		Cmd:  []string{"/bin/sh", "-c", execKiller, "sh", pidfile, forwardedSignals[sig]},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.client.ExecStart(d.ctx, execResp.ID, dockerClient.ExecStartOptions{Detach: true})
+	return err
+}
+
+// Checks a detach key sequence in docker's format: a comma separated list of
+// single characters or ctrl-<key>, such as "ctrl-p,ctrl-q".
+func validateDetachKeys(keys string) error {
+	for key := range strings.SplitSeq(keys, ",") {
+		if len(key) == 1 {
+			continue
+		}
+		ctrl, ok := strings.CutPrefix(strings.ToLower(key), "ctrl-")
+		if ok && len(ctrl) == 1 && (ctrl[0] >= 'a' && ctrl[0] <= 'z' || strings.Contains("@[\\]^_", ctrl)) {
+			continue
+		}
+		return fmt.Errorf("detach_keys: invalid key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// session dev106 attach opens when none is named.
+const DefaultTmuxSession = "main"
+
+// creates or attaches the session named by $1. It runs in a login shell, so
+// tmux and everything started in it see the same environment as a shell.
+const tmuxAttach = `command -v tmux >/dev/null 2>&1 || { echo "tmux is not installed in the image" >&2; exit 1; }
+exec tmux new-session -A -s "$1"`
+
+// lists something with tmux, printing nothing when tmux is missing or no
+// server is running.
+const tmuxList = `command -v tmux >/dev/null 2>&1 || exit 0
+tmux "$@" 2>/dev/null || true`
+
+// processes that count as an idle pane.
+var tmuxShells = map[string]bool{
+	"bash": true,
+	"sh":   true,
+	"dash": true,
+	"zsh":  true,
+	"fish": true,
+	"tmux": true,
+}
+
+// Checks a tmux session name; tmux reserves : and . for targets.
+func ValidateTmuxSession(name string) error {
+	if name == "" || strings.ContainsAny(name, ":.") {
+		return fmt.Errorf("invalid session name %q: it must be nonempty without : or .", name)
+	}
+	return nil
+}
+
+// Command for Exec that creates the tmux session name, or attaches to it if
+// it already exists.
+func TmuxAttachCmd(name string) []string {
+	return []string{"/bin/bash", "-lc", tmuxAttach, "bash", name}
+}
+
+type TmuxSession struct {
+	Name     string
+	Windows  int
+	Attached bool
+	Created  time.Time
+}
+
+// lists with tmux in the container, returning the tab separated fields of
+// each line.
+func (d *DevClient) tmuxList(containerName string, args ...string) ([][]string, error) {
+	cmd := append([]string{"/bin/sh", "-c", tmuxList, "sh"}, args...)
+	out, err := d.ExecOutput(containerName, cmd)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	for line := range strings.SplitSeq(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+	return rows, nil
+}
+
+// Returns the tmux sessions running in the container.
+func (d *DevClient) TmuxSessions(containerName string) ([]TmuxSession, error) {
+	rows, err := d.tmuxList(
+		containerName,
+		"list-sessions", "-F", "#{session_name}\t#{session_windows}\t#{session_attached}\t#{session_created}",
+	)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]TmuxSession, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 4 {
+			continue
+		}
+		windows, _ := strconv.Atoi(row[1])
+		attached, _ := strconv.Atoi(row[2])
+		created, _ := strconv.ParseInt(row[3], 10, 64)
+		sessions = append(sessions, TmuxSession{
+			Name:     row[0],
+			Windows:  windows,
+			Attached: attached > 0,
+			Created:  time.Unix(created, 0),
+		})
+	}
+	return sessions, nil
+}
+
+// A tmux pane running something other than a shell.
+type TmuxPane struct {
+	// session:window.pane
+	Target  string
+	Command string
+}
+
+// Returns the panes of all tmux sessions that are running a program rather
+// than sitting at a shell prompt.
+func (d *DevClient) BusyTmuxPanes(containerName string) ([]TmuxPane, error) {
+	rows, err := d.tmuxList(
+		containerName,
+		"list-panes", "-a", "-F", "#{session_name}:#{window_index}.#{pane_index}\t#{pane_current_command}",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var panes []TmuxPane
+	for _, row := range rows {
+		if len(row) != 2 || tmuxShells[strings.TrimPrefix(row[1], "-")] {
+			continue
+		}
+		panes = append(panes, TmuxPane{Target: row[0], Command: row[1]})
+	}
+	return panes, nil
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var ErrUntrustedConfig = errors.New("repository config is not trusted")
+
+// keys a repository's .dev106.toml may only set once the user has trusted
+// it. Anyone can commit a .dev106.toml, and these reach outside the container
+// (host files and environment, the ssh agent, git credentials) or pick what
+// runs with that access.
+var trustedKeys = map[string]bool{
+	"image":           true,
+	"build":           true,
+	"mounts":          true,
+	"passthrough":     true,
+	"env_file":        true,
+	"ssh_agent":       true,
+	"git_identity":    true,
+	"git_credentials": true,
+}
+
+// file recording the repository configs the user has trusted, by path and
+// the hash of the contents they reviewed.
+func trustPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trusted-configs.json"), nil
+}
+
+func readTrusted() (map[string]string, error) {
+	path, err := trustPath()
+	if err != nil {
+		return nil, err
+	}
+	trusted := map[string]string{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return trusted, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &trusted); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return trusted, nil
+}
+
+func configSum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// the keys in md that need trust, as written in the file (e.g.
+// profiles.big.mounts).
+func untrustedKeys(md toml.MetaData) []string {
+	var keys []string
+	for _, key := range md.Keys() {
+		switch {
+		case len(key) == 1 && trustedKeys[key[0]]:
+		case len(key) == 3 && key[0] == "profiles" && trustedKeys[key[2]]:
+		default:
+			continue
+		}
+		keys = append(keys, key.String())
+	}
+	return keys
+}
+
+// Fails if the repository config at path, with contents data, sets keys that
+// need trust and the user hasn't trusted these contents.
+func checkTrust(path string, data []byte, md toml.MetaData) error {
+	keys := untrustedKeys(md)
+	if len(keys) == 0 {
+		return nil
+	}
+	trusted, err := readTrusted()
+	if err != nil {
+		return err
+	}
+	if trusted[path] == configSum(data) {
+		return nil
+	}
+	return &ConfigError{Path: path, Err: fmt.Errorf(
+		"%w: it sets %s; review it and run `dev106 config trust`",
+		ErrUntrustedConfig, strings.Join(keys, ", "),
+	)}
+}
+
+// Trusts data as the contents of the repository config at path, returning
+// the keys it sets that needed trust. Changing the file revokes the trust.
+func TrustRepoConfig(path string, data []byte) ([]string, error) {
+	md, err := toml.Decode(string(data), &struct{}{})
+	if err != nil {
+		return nil, &ConfigError{Path: path, Err: err}
+	}
+
+	trusted, err := readTrusted()
+	if err != nil {
+		return nil, err
+	}
+	trusted[path] = configSum(data)
+	// drops configs that no longer exist.
+	for other := range trusted {
+		if _, err := os.Stat(other); errors.Is(err, os.ErrNotExist) {
+			delete(trusted, other)
+		}
+	}
+
+	out, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	file, err := trustPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, err
+	}
+	tmp := file + fmt.Sprintf(".%d.tmp", os.Getpid())
+	if err := os.WriteFile(tmp, out, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return nil, err
+	}
+	return untrustedKeys(md), nil
+}
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/go-units"
+	"github.com/junikimm717/dev106/internal/shared"
+	dockerClient "github.com/moby/moby/client"
+)
+
+// how often the update_check runs at most.
+const updateCheckInterval = 24 * time.Hour
+
+// The local and registry digests of an image reference.
+type ImageUpdate struct {
+	Image string
+	// digest of the local copy, or "" if the image has never been pulled.
+	Local string
+	// when the local copy was built, if known.
+	LocalCreated time.Time
+	// digest the registry currently serves for the reference.
+	Remote string
+}
+
+// Whether the registry has a different image than the local copy.
+func (u *ImageUpdate) Available() bool {
+	return u.Local != u.Remote
+}
+
+// Describes the change for the user.
+func (u *ImageUpdate) String() string {
+	if !u.Available() {
+		return fmt.Sprintf("%s is up to date (%s)", u.Image, shortDigest(u.Remote))
+	}
+	if u.Local == "" {
+		return fmt.Sprintf("%s has not been pulled yet; the registry has %s", u.Image, shortDigest(u.Remote))
+	}
+	local := "local"
+	if !u.LocalCreated.IsZero() {
+		local += fmt.Sprintf(", built %s ago", units.HumanDuration(time.Since(u.LocalCreated)))
+	}
+	return fmt.Sprintf(
+		"%s has been updated: %s (%s) -> %s (registry)",
+		u.Image, shortDigest(u.Local), local, shortDigest(u.Remote),
+	)
+}
+
+func shortDigest(digest string) string {
+	if _, hex, ok := strings.Cut(digest, ":"); ok && len(hex) > 12 {
+		return digest[:len(digest)-len(hex)+12]
+	}
+	return digest
+}
+
+// Compares the digest of the local copy of image with the digest the
+// registry serves for it.
+func (d *DevClient) CheckUpdate(image string) (*ImageUpdate, error) {
+	if strings.HasPrefix(image, buildRepository+":") {
+		return nil, fmt.Errorf("%s is built locally from the [build] section", image)
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := registryAuth(image)
+	if err != nil {
+		return nil, err
+	}
+	dist, err := d.client.DistributionInspect(d.ctx, image, dockerClient.DistributionInspectOptions{
+		EncodedRegistryAuth: auth,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not reach the registry for %s: %w", image, err)
+	}
+	update := &ImageUpdate{
+		Image:  image,
+		Remote: dist.Descriptor.Digest.String(),
+	}
+
+	local, err := d.client.ImageInspect(d.ctx, image)
+	if errdefs.IsNotFound(err) {
+		return update, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// RepoDigests holds name@digest for every repository the image was
+	// pulled from.
+	for _, repoDigest := range local.RepoDigests {
+		ref, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil || ref.Name() != named.Name() {
+			continue
+		}
+		if canonical, ok := ref.(reference.Canonical); ok {
+			update.Local = canonical.Digest().String()
+			if update.Local == update.Remote {
+				break
+			}
+		}
+	}
+	if created, err := time.Parse(time.RFC3339Nano, local.Created); err == nil {
+		update.LocalCreated = created
+	}
+	return update, nil
+}
+
+// directory for state that should persist between runs.
+func stateDir() (string, error) {
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, shared.APPNAME), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", shared.APPNAME), nil
+}
+
+func updateCheckPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "last-update-check"), nil
+}
+
+// Whether the last update check was long enough ago to run another.
+func UpdateCheckDue() bool {
+	path, err := updateCheckPath()
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return true
+	}
+	return time.Since(last) >= updateCheckInterval
+}
+
+// Records that an update check just ran.
+func RecordUpdateCheck() error {
+	path, err := updateCheckPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o644)
+}
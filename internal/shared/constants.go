@@ -4,4 +4,28 @@ const (
 	CONTAINER_PREFIX = "dev106"
 	CONTAINER_HOME   = "/home/dev106"
 	APPNAME          = "dev106"
+	// where the repository root is mounted.
+	CONTAINER_WORKSPACE = "/workspace"
+
+	// where the bootstrapper publishes its progress inside the container.
+	STATUS_DIR  = "/run/dev106"
+	STATUS_FILE = "/run/dev106/status.json"
+	// host directory shared with the container for the CLI's relays.
+	SESSION_DIR = "/run/dev106/session"
+	// git config the CLI generates for sessions, used as git's system config.
+	GIT_CONFIG = "/run/dev106/session/gitconfig"
+	// argument that runs the bootstrapper as a git credential helper, and
+	// the variable naming the socket it forwards requests to.
+	GIT_CREDENTIAL_HELPER_ARG = "--git-credential-helper"
+	GIT_CREDENTIAL_SOCK_ENV   = "DEV106_GIT_CREDENTIAL_SOCK"
+	// where Docker Desktop's ssh agent socket is mounted on macOS hosts.
+	SSH_AGENT_SOCK = "/run/dev106/ssh-agent.sock"
+	// executables run by the bootstrapper (as root) before the container is
+	// ready, in lexical order.
+	HOOKS_DIR = "/etc/dev106/hooks.d"
+
+	// prefixes of bootstrapper log lines for failures and problems it carried
+	// on past, so `dev106 logs` can point them out.
+	LOG_ERROR_PREFIX   = "dev106-bootstrap: error: "
+	LOG_WARNING_PREFIX = "dev106-bootstrap: warning: "
 )
@@ -0,0 +1,23 @@
+package shared
+
+import "time"
+
+// phases of the bootstrapper, in the order it goes through them.
+type Phase string
+
+const (
+	PhaseStarting   Phase = "starting"
+	PhaseEtcRewrite Phase = "etc-rewrite"
+	PhaseChown      Phase = "chown"
+	PhaseHooks      Phase = "hooks"
+	PhaseReady      Phase = "ready"
+)
+
+// Contents of STATUS_FILE. Error is set if the bootstrapper failed during
+// Phase; Warnings hold problems it carried on past.
+type BootstrapStatus struct {
+	Phase    Phase     `json:"phase"`
+	Error    string    `json:"error,omitempty"`
+	Warnings []string  `json:"warnings,omitempty"`
+	Time     time.Time `json:"time"`
+}
@@ -2,45 +2,103 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"slices"
+	"time"
 
+	"github.com/containerd/errdefs"
 	"github.com/junikimm717/dev106/internal/cli"
+	"github.com/junikimm717/dev106/internal/shared"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
 	"github.com/spf13/cobra"
 )
 
+// values of the global flags.
+var (
+	profileFlag  string
+	platformFlag string
+)
+
+// values of --recreate and --no-recreate, for the commands that have them.
+var (
+	recreateFlag   bool
+	noRecreateFlag bool
+)
+
+// value of -w, for the commands that have it.
+var workdirFlag string
+
+func addWorkdirFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&workdirFlag, "workdir", "w", "", "directory in the container to start in (relative paths are relative to /workspace)")
+}
+
+func addRecreateFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&recreateFlag, "recreate", false, "recreate the container if it is out of date")
+	cmd.Flags().BoolVar(&noRecreateFlag, "no-recreate", false, "keep using the container even if it is out of date")
+	cmd.MarkFlagsMutuallyExclusive("recreate", "no-recreate")
+}
+
 type App struct {
 	Config        *cli.DevConfig
 	Client        *cli.DevClient
 	ContainerName string
+	Root          string
 	Binds         []string
+	Mounts        []mount.Mount
+	Env           []string
+	// container path matching the host working directory.
+	WorkDir string
+	// whether Config.Image has been resolved for the [build] section.
+	imageResolved bool
+	// whether Env, and Binds and Mounts, have been filled in.
+	envLoaded    bool
+	mountsLoaded bool
 }
 
 // Function that generates a new app. It contains an option for whether it is
 // strictly required that we are in some Git repository.
 func newApp(allowNoRoot bool) (*App, error) {
-	config, err := cli.LoadConfig()
-	if err != nil {
-		return nil, err
-	}
 	ctx := context.Background()
-	client := cli.NewClient(ctx)
 
 	wd, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
 
-	root, err := cli.FindRoot(wd)
+	root, rootErr := cli.FindRoot(wd)
+	if rootErr != nil {
+		if !allowNoRoot {
+			return nil, rootErr
+		}
+		root = ""
+	}
+
+	// the repository's .dev106.toml (if any) is layered over the global config.
+	config, err := cli.LoadConfig(root, profileFlag)
+	if errors.Is(err, cli.ErrNoConfig) {
+		return nil, fmt.Errorf("%w\nRun `dev106 config init` to create one.", err)
+	}
 	if err != nil {
-		if allowNoRoot {
-			return &App{
-				Config: config,
-				Client: client,
-			}, nil
-		} else {
+		return nil, err
+	}
+	if platformFlag != "" {
+		if _, err := cli.ParsePlatform(platformFlag); err != nil {
 			return nil, err
 		}
+		config.Platform = platformFlag
+		config.Sources["platform"] = "--platform"
+	}
+	client := cli.NewClient(ctx)
+
+	if root == "" {
+		return &App{
+			Config: config,
+			Client: client,
+		}, nil
 	}
 
 	binds, err := cli.BindMounts(config, root)
@@ -55,14 +113,237 @@ func newApp(allowNoRoot bool) (*App, error) {
 		}
 	}
 
+	// the config's mounts and environment are only read by the commands
+	// that need them; see prepare.
 	return &App{
 		Config:        config,
 		Client:        client,
-		ContainerName: cli.ContainerName(root),
+		ContainerName: cli.ContainerName(root, config.Profile),
+		Root:          root,
 		Binds:         binds,
+		WorkDir:       cli.ContainerWorkDir(root, wd),
+	}, nil
+}
+
+// reads the environment (env, passthrough and env_file) for the app's
+// container and its sessions, so that a missing env_file only breaks the
+// commands that create containers or start sessions.
+func (a *App) loadEnv() error {
+	if a.envLoaded {
+		return nil
+	}
+	env, err := cli.Environment(a.Config, a.Root)
+	if err != nil {
+		return err
+	}
+	a.Env = env
+	a.envLoaded = true
+	return nil
+}
+
+// works out the mounts and environment of a new container, checking that
+// the sources of the config's mounts exist.
+func (a *App) prepare() error {
+	if err := a.loadEnv(); err != nil {
+		return err
+	}
+	if a.mountsLoaded {
+		return nil
+	}
+	mounts, err := cli.ConfigMounts(a.Config, a.Root)
+	if err != nil {
+		return err
+	}
+	sessionBinds, err := cli.SessionMounts(a.Config, a.ContainerName)
+	if err != nil {
+		return err
+	}
+	a.Mounts = mounts
+	a.Binds = append(a.Binds, sessionBinds...)
+	a.mountsLoaded = true
+	return nil
+}
+
+// options for sessions in the app's container. -w overrides the directory
+// mapped from the host.
+func (a *App) execOptions() (cli.ExecOptions, error) {
+	if err := a.loadEnv(); err != nil {
+		return cli.ExecOptions{}, err
+	}
+	workdir := a.WorkDir
+	if workdirFlag != "" {
+		workdir = path.Join(shared.CONTAINER_WORKSPACE, workdirFlag)
+		if path.IsAbs(workdirFlag) {
+			workdir = path.Clean(workdirFlag)
+		}
+	}
+	return cli.ExecOptions{
+		Env:        a.Env,
+		WorkingDir: workdir,
+		DetachKeys: a.Config.DetachKeys,
 	}, nil
 }
 
+// sets up the host side of a session in the app's container, returning the
+// options for the session.
+func (a *App) startSession() (cli.ExecOptions, error) {
+	opts, err := a.execOptions()
+	if err != nil {
+		return opts, err
+	}
+	env, err := a.Client.StartSession(a.Config, a.ContainerName, a.Root)
+	if err != nil {
+		return cli.ExecOptions{}, err
+	}
+	opts.Env = append(slices.Clone(opts.Env), env...)
+	return opts, nil
+}
+
+// points the config's image at the tag of the [build] section's image. That
+// means hashing the whole build context, so only commands that build images
+// or create containers (or compare them with the config) do it.
+func (a *App) resolveImage() error {
+	if a.imageResolved {
+		return nil
+	}
+	if err := cli.ResolveBuild(a.Config, a.Root); err != nil {
+		return err
+	}
+	a.imageResolved = true
+	return nil
+}
+
+// creates and starts the app's container, and waits for it to be ready.
+func (a *App) start() error {
+	if err := a.resolveImage(); err != nil {
+		return err
+	}
+	if err := a.prepare(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Starting new container %s\n", a.ContainerName)
+	err := a.Client.Run(a.Config, a.ContainerName, cli.RunOptions{
+		Root:   a.Root,
+		Binds:  a.Binds,
+		Mounts: a.Mounts,
+		Env:    a.Env,
+	})
+	if err != nil {
+		return err
+	}
+	return a.waitReady()
+}
+
+func (a *App) waitReady() error {
+	timeout, err := a.Config.ReadyTimeoutDuration()
+	if err != nil {
+		return err
+	}
+	return a.Client.WaitReady(a.ContainerName, timeout)
+}
+
+// makes sure the app's container is running, creating it or resuming a
+// stopped one if needed. If the container is out of date with the image or
+// config, offers to recreate it.
+func (a *App) ensure() error {
+	if err := a.resolveImage(); err != nil {
+		return err
+	}
+	// rebuild first, so a changed Dockerfile shows up as an out of date
+	// container below.
+	if err := a.Client.EnsureBuilt(a.Config); err != nil {
+		return err
+	}
+	state, err := a.Client.ContainerState(a.ContainerName)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case "":
+		return a.start()
+	case container.StateRunning:
+	case container.StateDead, container.StateRemoving:
+		// can't be brought back.
+		if err := a.Client.Delete(a.ContainerName); err != nil {
+			return err
+		}
+		return a.start()
+	default:
+		fmt.Fprintf(os.Stderr, "Resuming container %s\n", a.ContainerName)
+		if err := a.Client.Resume(a.ContainerName); err != nil {
+			return err
+		}
+		if state != container.StatePaused {
+			if err := a.waitReady(); err != nil {
+				return err
+			}
+		}
+	}
+
+	reasons, err := a.Client.StaleReasons(a.ContainerName, a.Config)
+	if err != nil || len(reasons) == 0 || noRecreateFlag {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: container %s is out of date:\n", a.ContainerName)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "  - %s\n", reason)
+	}
+	if !recreateFlag && !confirm("Recreate it now? Changes outside /workspace will be lost.", false) {
+		fmt.Fprintln(os.Stderr, "Using the existing container (pass --recreate to recreate it).")
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Killing container %s\n", a.ContainerName)
+	if err := a.Client.Delete(a.ContainerName); err != nil {
+		return err
+	}
+	return a.start()
+}
+
+// Makes a recreated container keep the platform the current one was created
+// for, unless the config or --platform sets one.
+func (a *App) keepPlatform() error {
+	if a.Config.Platform != "" {
+		return nil
+	}
+	labels, err := a.Client.ContainerLabels(a.ContainerName)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	if platform := labels[cli.LabelPlatform]; platform != "" {
+		a.Config.Platform = platform
+		// the built image's tag depends on the platform.
+		a.imageResolved = false
+		return a.resolveImage()
+	}
+	return nil
+}
+
+// how long the update_check may take before we give up on it.
+const updateCheckTimeout = 5 * time.Second
+
+// Starts the update_check in the background if it is enabled and hasn't run
+// today. The channel yields a notice to show, or "" if there is none.
+func (a *App) checkForUpdate() <-chan string {
+	notice := make(chan string, 1)
+	if !a.Config.UpdateCheck || a.Config.Build != nil || !cli.UpdateCheckDue() {
+		notice <- ""
+		return notice
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+		defer cancel()
+		update, err := cli.NewClient(ctx).CheckUpdate(a.Config.Image)
+		_ = cli.RecordUpdateCheck()
+		if err != nil || !update.Available() || update.Local == "" {
+			notice <- ""
+			return
+		}
+		notice <- fmt.Sprintf("Note: %s\nRun `dev106 update` to pull it.", update)
+	}()
+	return notice
+}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "dev106",
@@ -77,16 +358,39 @@ func main() {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 	}
+	addRecreateFlags(rootCmd)
+	addWorkdirFlag(rootCmd)
+
+	rootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "config profile to use (overrides default_profile)")
+	rootCmd.PersistentFlags().StringVar(&platformFlag, "platform", "", "platform to run the image as, e.g. linux/arm64")
 
 	rootCmd.AddCommand(pullCmd())
+	rootCmd.AddCommand(updateCmd())
+	rootCmd.AddCommand(buildCmd())
+	rootCmd.AddCommand(loginCmd())
 	rootCmd.AddCommand(startCmd())
+	rootCmd.AddCommand(stopCmd())
 	rootCmd.AddCommand(shellCmd())
 	rootCmd.AddCommand(killCmd())
 	rootCmd.AddCommand(restartCmd())
 	rootCmd.AddCommand(execCmd())
+	rootCmd.AddCommand(attachCmd())
+	rootCmd.AddCommand(sessionsCmd())
+	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(limitsCmd())
+	rootCmd.AddCommand(portCmd())
+	rootCmd.AddCommand(lsCmd())
+	rootCmd.AddCommand(logsCmd())
+	rootCmd.AddCommand(pruneCmd())
+	rootCmd.AddCommand(sessionRelayCmd())
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		// commands run in the container exit with their own status.
+		var exitErr *cli.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// whether we can ask the user questions on stdin.
+func interactive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// prints question and returns the trimmed line the user typed.
+func prompt(question string) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// asks a yes/no question. An empty answer (or a non-interactive stdin) picks
+// def.
+func confirm(question string, def bool) bool {
+	if !interactive() {
+		return def
+	}
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	answer, err := prompt(fmt.Sprintf("%s %s ", question, hint))
+	if err != nil || answer == "" {
+		return def
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+// like prompt, without echoing what the user types.
+func promptPassword(question string) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}